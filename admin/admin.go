@@ -0,0 +1,129 @@
+// Package admin provides an http.Handler to inspect the live connections of an Enzo.
+// It has no authentication of its own, mount it behind yours:
+//
+//	http.Handle("/admin/", http.StripPrefix("/admin", admin.New(enzo)))
+//
+// Routes:
+//
+//	GET  /connections                        list the connections of this node
+//	GET  /connections/{connid}               one connection
+//	POST /connections/{connid}/kick          close it, query: code (default 4001), reason
+//	POST /connections/{connid}/emit?key=...  emit the body as data and wait for the reply
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	enzogo "github.com/cuipeiyu/enzo.go"
+	"github.com/cuipeiyu/enzo.go/internal/httpemit"
+)
+
+var errMissingKey = errors.New("missing key")
+
+// the body of a test message is limited
+const maxEmitSize = 1 << 20
+
+type Admin struct {
+	enzo *enzogo.Enzo
+}
+
+func New(enzo *enzogo.Enzo) *Admin {
+	return &Admin{enzo: enzo}
+}
+
+func (a *Admin) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "connections" {
+		http.NotFound(rw, r)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(rw, http.StatusOK, a.enzo.Connections())
+
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		info, ok := a.enzo.Connection(parts[1])
+		if !ok {
+			writeError(rw, http.StatusNotFound, enzogo.ErrConnNotFound)
+			return
+		}
+		writeJSON(rw, http.StatusOK, info)
+
+	case len(parts) == 3 && parts[2] == "kick" && r.Method == http.MethodPost:
+		a.kick(rw, r, parts[1])
+
+	case len(parts) == 3 && parts[2] == "emit" && r.Method == http.MethodPost:
+		a.emit(rw, r, parts[1])
+
+	default:
+		http.NotFound(rw, r)
+	}
+}
+
+func (a *Admin) kick(rw http.ResponseWriter, r *http.Request, connid string) {
+	code := enzogo.CloseKicked
+	if s := r.URL.Query().Get("code"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+		code = n
+	}
+
+	err := a.enzo.Kick(connid, code, r.URL.Query().Get("reason"))
+	if err == enzogo.ErrConnNotFound {
+		writeError(rw, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+type emitResult struct {
+	Reply string `json:"reply"`
+	Error string `json:"error,omitempty"`
+}
+
+func (a *Admin) emit(rw http.ResponseWriter, r *http.Request, connid string) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeError(rw, http.StatusBadRequest, errMissingKey)
+		return
+	}
+
+	data, err := httpemit.ReadBody(r, maxEmitSize)
+	if err == httpemit.ErrTooLarge {
+		writeError(rw, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	reply, err := httpemit.Emit(r, a.enzo, connid, key, data)
+	if err != nil {
+		writeJSON(rw, httpemit.Status(err), emitResult{Error: err.Error()})
+		return
+	}
+	writeJSON(rw, http.StatusOK, emitResult{Reply: string(reply)})
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(v)
+}
+
+func writeError(rw http.ResponseWriter, status int, err error) {
+	writeJSON(rw, status, map[string]string{"error": err.Error()})
+}
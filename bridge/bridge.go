@@ -0,0 +1,155 @@
+// Package bridge provides an http.Handler forwarding REST calls to the connections of an Enzo,
+// for the services which can not link the library:
+//
+//	http.Handle("/push/", http.StripPrefix("/push", bridge.New(enzo, bridge.Token(secret))))
+//
+// Routes, the body of the request is the data of the message:
+//
+//	POST /emit/{connid}/{key}   to a connection, ?wait=1 waits for the reply and returns its data
+//	POST /broadcast/{key}       to every connection
+//	POST /rooms/{room}/{key}    to every connection of a room
+//
+// The path segments are url escaped. Without wait the response is 202 Accepted.
+package bridge
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+
+	enzogo "github.com/cuipeiyu/enzo.go"
+	"github.com/cuipeiyu/enzo.go/internal/httpemit"
+)
+
+// the body of a request is limited
+const maxBodySize = 1 << 20
+
+// Authenticator decides whether a request may push messages
+type Authenticator func(r *http.Request) bool
+
+// Token authenticates the requests carrying "Authorization: Bearer <token>"
+func Token(token string) Authenticator {
+	want := []byte("Bearer " + token)
+	return func(r *http.Request) bool {
+		got := []byte(r.Header.Get("Authorization"))
+		return token != "" && subtle.ConstantTimeCompare(got, want) == 1
+	}
+}
+
+type Bridge struct {
+	enzo         *enzogo.Enzo
+	authenticate Authenticator
+}
+
+// New returns a bridge, a nil authenticate rejects every request
+func New(enzo *enzogo.Enzo, authenticate Authenticator) *Bridge {
+	return &Bridge{
+		enzo:         enzo,
+		authenticate: authenticate,
+	}
+}
+
+func (b *Bridge) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if b.authenticate == nil || !b.authenticate(r) {
+		http.Error(rw, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts, err := segments(r.URL)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case len(parts) == 3 && parts[0] == "emit":
+		b.emit(rw, r, parts[1], parts[2])
+	case len(parts) == 2 && parts[0] == "broadcast":
+		b.push(rw, r, func(data []byte) error {
+			return b.enzo.Broadcast(parts[1], data)
+		})
+	case len(parts) == 3 && parts[0] == "rooms":
+		b.push(rw, r, func(data []byte) error {
+			return b.enzo.EmitRoom(parts[1], parts[2], data)
+		})
+	default:
+		http.NotFound(rw, r)
+	}
+}
+
+func (b *Bridge) push(rw http.ResponseWriter, r *http.Request, send func(data []byte) error) {
+	data, ok := readBody(rw, r)
+	if !ok {
+		return
+	}
+
+	if err := send(data); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return
+	}
+	rw.WriteHeader(http.StatusAccepted)
+}
+
+func (b *Bridge) emit(rw http.ResponseWriter, r *http.Request, connid, key string) {
+	data, ok := readBody(rw, r)
+	if !ok {
+		return
+	}
+
+	wait := r.URL.Query().Get("wait")
+	if wait == "" || wait == "0" || wait == "false" {
+		err := b.enzo.EmitTo(connid, key, data)
+		if err != nil {
+			writeError(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	reply, err := httpemit.Emit(r, b.enzo, connid, key, data)
+	if err != nil {
+		writeError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(reply)
+}
+
+// segments splits the escaped path, so a key or a room may contain a slash
+func segments(u *url.URL) ([]string, error) {
+	parts := strings.Split(strings.Trim(u.EscapedPath(), "/"), "/")
+	for i, p := range parts {
+		s, err := url.PathUnescape(p)
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = s
+	}
+	return parts, nil
+}
+
+func readBody(rw http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	data, err := httpemit.ReadBody(r, maxBodySize)
+	if err == httpemit.ErrTooLarge {
+		http.Error(rw, err.Error(), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return data, true
+}
+
+func writeError(rw http.ResponseWriter, err error) {
+	http.Error(rw, err.Error(), httpemit.Status(err))
+}
@@ -0,0 +1,76 @@
+package memory
+
+import (
+	"errors"
+	"sync"
+
+	enzogo "github.com/cuipeiyu/enzo.go"
+)
+
+// New returns a hub relaying envelopes between the brokers of one process,
+// e.g. to run several Enzo nodes in tests.
+func New() *Hub {
+	return &Hub{brokers: map[*Broker]struct{}{}}
+}
+
+type Hub struct {
+	mux     sync.Mutex
+	brokers map[*Broker]struct{}
+}
+
+// Broker returns a new broker attached to the hub, one for every node
+func (h *Hub) Broker() *Broker {
+	b := &Broker{hub: h}
+
+	h.mux.Lock()
+	h.brokers[b] = struct{}{}
+	h.mux.Unlock()
+
+	return b
+}
+
+func (h *Hub) publish(from *Broker, e enzogo.Envelope) {
+	h.mux.Lock()
+	handlers := make([]func(enzogo.Envelope), 0, len(h.brokers))
+	for b := range h.brokers {
+		if b != from && b.handler != nil {
+			handlers = append(handlers, b.handler)
+		}
+	}
+	h.mux.Unlock()
+
+	for _, handler := range handlers {
+		handler(e)
+	}
+}
+
+var _ enzogo.Broker = (*Broker)(nil)
+
+type Broker struct {
+	hub     *Hub
+	handler func(enzogo.Envelope)
+}
+
+func (b *Broker) Publish(e enzogo.Envelope) error {
+	b.hub.publish(b, e)
+	return nil
+}
+
+func (b *Broker) Subscribe(handler func(enzogo.Envelope)) error {
+	b.hub.mux.Lock()
+	defer b.hub.mux.Unlock()
+
+	if _, ok := b.hub.brokers[b]; !ok {
+		return errors.New("broker closed")
+	}
+	b.handler = handler
+	return nil
+}
+
+func (b *Broker) Close() error {
+	b.hub.mux.Lock()
+	defer b.hub.mux.Unlock()
+
+	delete(b.hub.brokers, b)
+	return nil
+}
@@ -0,0 +1,268 @@
+// Package tcp is a reference broker relaying envelopes over TCP:
+// one Server, and a Broker dialed by every node.
+package tcp
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	enzogo "github.com/cuipeiyu/enzo.go"
+)
+
+// NewServer returns a server relaying every envelope to all the other connected brokers
+func NewServer() *Server {
+	return &Server{peers: map[*peer]struct{}{}}
+}
+
+type Server struct {
+	mux   sync.Mutex
+	peers map[*peer]struct{}
+}
+
+const (
+	// envelopes waiting to be relayed to a peer, one falling further behind is dropped
+	// and its broker reconnects
+	peerQueue = 1024
+	// a peer which does not take an envelope for this long is dropped
+	writeTimeout = 10 * time.Second
+)
+
+// peer is a connected broker, every peer is written by its own goroutine
+// so a stalled one does not hold up the others
+type peer struct {
+	conn  net.Conn
+	queue chan enzogo.Envelope
+	done  chan struct{}
+}
+
+// send queues the envelope, it returns false when the queue of the peer is full
+func (p *peer) send(e enzogo.Envelope) bool {
+	select {
+	case p.queue <- e:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *peer) write() {
+	enc := json.NewEncoder(p.conn)
+	for {
+		select {
+		case e := <-p.queue:
+			p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := enc.Encode(e); err != nil {
+				log.Println("relay envelope error:", err)
+				p.conn.Close()
+				return
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// Serve accepts the brokers until the listener is closed
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	p := &peer{
+		conn:  conn,
+		queue: make(chan enzogo.Envelope, peerQueue),
+		done:  make(chan struct{}),
+	}
+	defer close(p.done)
+	go p.write()
+
+	s.mux.Lock()
+	s.peers[p] = struct{}{}
+	s.mux.Unlock()
+
+	defer func() {
+		s.mux.Lock()
+		delete(s.peers, p)
+		s.mux.Unlock()
+	}()
+
+	dec := json.NewDecoder(conn)
+	for {
+		var e enzogo.Envelope
+		if err := dec.Decode(&e); err != nil {
+			return
+		}
+
+		s.mux.Lock()
+		peers := make([]*peer, 0, len(s.peers))
+		for other := range s.peers {
+			if other != p {
+				peers = append(peers, other)
+			}
+		}
+		s.mux.Unlock()
+
+		for _, other := range peers {
+			if !other.send(e) {
+				log.Println("relay envelope error: peer too slow, dropped")
+				other.conn.Close()
+			}
+		}
+	}
+}
+
+// the delays between the attempts to reconnect a lost broker, doubled up to maxBackoff
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 10 * time.Second
+)
+
+var errDisconnected = errors.New("broker disconnected")
+
+// Dial connects a broker to the server at addr. A lost connection is dialed again with
+// an increasing delay, Publish fails meanwhile and the envelopes of the other nodes are missed.
+func Dial(addr string) (*Broker, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Broker{
+		addr: addr,
+		conn: conn,
+		enc:  json.NewEncoder(conn),
+		done: make(chan struct{}),
+	}
+	go b.read(conn)
+
+	return b, nil
+}
+
+var _ enzogo.Broker = (*Broker)(nil)
+
+type Broker struct {
+	addr string
+
+	mux sync.Mutex
+	// nil while reconnecting
+	conn    net.Conn
+	enc     *json.Encoder
+	handler func(enzogo.Envelope)
+	closed  bool
+	done    chan struct{}
+}
+
+func (b *Broker) Publish(e enzogo.Envelope) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.closed {
+		return errors.New("broker closed")
+	}
+	if b.conn == nil {
+		return errDisconnected
+	}
+	return b.enc.Encode(e)
+}
+
+func (b *Broker) Subscribe(handler func(enzogo.Envelope)) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.handler = handler
+	return nil
+}
+
+func (b *Broker) Close() error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	if b.conn == nil {
+		return nil
+	}
+	return b.conn.Close()
+}
+
+func (b *Broker) read(conn net.Conn) {
+	for {
+		dec := json.NewDecoder(conn)
+		for {
+			var e enzogo.Envelope
+			if err := dec.Decode(&e); err != nil {
+				b.mux.Lock()
+				closed := b.closed
+				b.mux.Unlock()
+				if closed {
+					return
+				}
+				log.Println("broker connection lost:", err)
+				break
+			}
+
+			b.mux.Lock()
+			handler := b.handler
+			b.mux.Unlock()
+
+			if handler != nil {
+				handler(e)
+			}
+		}
+
+		conn.Close()
+		if conn = b.reconnect(); conn == nil {
+			return
+		}
+	}
+}
+
+// reconnect dials the server until it answers, it returns nil once the broker is closed
+func (b *Broker) reconnect() net.Conn {
+	b.mux.Lock()
+	b.conn, b.enc = nil, nil
+	b.mux.Unlock()
+
+	backoff := minBackoff
+	for {
+		select {
+		case <-b.done:
+			return nil
+		case <-time.After(backoff):
+		}
+
+		conn, err := net.Dial("tcp", b.addr)
+		if err != nil {
+			log.Println("broker reconnect error:", err)
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+
+		b.mux.Lock()
+		if b.closed {
+			b.mux.Unlock()
+			conn.Close()
+			return nil
+		}
+		b.conn, b.enc = conn, json.NewEncoder(conn)
+		b.mux.Unlock()
+		return conn
+	}
+}
@@ -0,0 +1,79 @@
+package enzogo
+
+import (
+	"context"
+	"sync"
+)
+
+// Context returns the context of the message handling, it is cancelled when the client
+// cancels the message, after the reply, or when the connection is closed.
+func (ctx *Context) Context() context.Context {
+	if ctx.goCtx != nil {
+		return ctx.goCtx
+	}
+	return ctx.conn.ctx
+}
+
+// track makes a cancellable context for an incoming message
+func (c *connection) track(msgid []byte) context.Context {
+	goCtx, cancel := context.WithCancel(c.ctx)
+	c.cancels.Store(bytes2BHex(msgid), cancel)
+	return goCtx
+}
+
+// release cancels the context of an incoming message, after its reply or on a CancelMessage
+func (c *connection) release(msgid []byte) {
+	if cancel, ok := c.cancels.LoadAndDelete(bytes2BHex(msgid)); ok {
+		cancel.(context.CancelFunc)()
+	}
+}
+
+// EmitWithContext is like Emit, but sends a CancelMessage to the client when goCtx is done
+// before the reply, the callback then receives the error of goCtx.
+func (ctx *Context) EmitWithContext(goCtx context.Context, key string, data []byte, cb ...Handle) error {
+	return ctx.emitWithContext(goCtx, false, key, data, cb)
+}
+
+// LongtimeEmitWithContext is like LongtimeEmit, but can be cancelled as EmitWithContext.
+func (ctx *Context) LongtimeEmitWithContext(goCtx context.Context, key string, data []byte, cb ...Handle) error {
+	return ctx.emitWithContext(goCtx, true, key, data, cb)
+}
+
+func (ctx *Context) emitWithContext(goCtx context.Context, longtime bool, key string, data []byte, cb []Handle) error {
+	if err := goCtx.Err(); err != nil {
+		return err
+	}
+
+	msgid := makeMsgId()
+
+	var callback Handle
+
+	if cb == nil {
+		callback = func(ctx *Context) {}
+	} else {
+		callback = cb[0]
+	}
+
+	done := make(chan struct{})
+	once := sync.Once{}
+	finish := func(res *Context) {
+		once.Do(func() {
+			close(done)
+			callback(res)
+		})
+	}
+
+	ctx.write(PostMessage, longtime, msgid, key, data, finish)
+
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.conn.ctx.Done():
+		case <-goCtx.Done():
+			ctx.write(CancelMessage, false, msgid, "", nil, func(ctx *Context) {})
+			finish(ctx.errorContext(goCtx.Err()))
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,358 @@
+package enzogo
+
+import (
+	"context"
+	"encoding/binary"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client is the Go side of a connection: it emits to the handlers of the server
+// and serves the emits of the server with its own handlers.
+type Client struct {
+	transport Transport
+	protocol  string
+	features  features
+
+	// outgoing sequence, with enzo-v2
+	writeLock sync.Mutex
+	seq       uint64
+
+	lock      sync.Mutex
+	handlers  map[string]ClientHandle
+	unhandled ClientHandle
+	// replies awaited by msgid
+	pending map[string]chan payload
+
+	// runs the default replies, see SetClock
+	clock Clock
+
+	closed chan struct{}
+	err    error
+}
+
+// ClientHandle serves a message emitted by the server, the reply is written with Message.Write
+type ClientHandle func(m *Message)
+
+// Message is a message received by a Client, an emit of the server or a reply
+type Message struct {
+	client  *Client
+	payload payload
+	timer   Timer
+	replied bool
+	lock    sync.Mutex
+}
+
+// Dial connects to a server of Enzo.Serve, network is "tcp" or "unix"
+func Dial(network, address string) (*Client, error) {
+	return DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to a server of Enzo.Serve, the context covers the dial and the handshake
+func DialContext(ctx context.Context, network, address string) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	t := NewConnTransport(conn)
+	if err := t.WriteFrame([]byte(strings.Join([]string{ProtocolV2, ProtocolV1, ProtocolV0}, ","))); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	protocol, err := t.ReadFrame()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, ok := knownProtocols[string(protocol)]; !ok {
+		t.Close(CloseProtocolError, "unsupported protocol")
+		return nil, &CloseError{Code: CloseProtocolError, Text: "unsupported protocol"}
+	}
+
+	conn.SetDeadline(time.Time{})
+	return NewClient(t, string(protocol)), nil
+}
+
+// NewClient runs a client over a transport connected to a server speaking the protocol,
+// e.g. an end of NewPipe whose other end is served with Enzo.ServeTransport.
+func NewClient(t Transport, protocol string) *Client {
+	c := &Client{
+		transport: t,
+		protocol:  protocol,
+		features:  knownProtocols[protocol],
+		handlers:  map[string]ClientHandle{},
+		pending:   map[string]chan payload{},
+		clock:     systemClock{},
+		closed:    make(chan struct{}),
+	}
+
+	go c.read()
+	return c
+}
+
+// Protocol returns the protocol version spoken with the server
+func (c *Client) Protocol() string {
+	return c.protocol
+}
+
+// SetClock replaces the clock of the default replies, nil restores the system clock
+func (c *Client) SetClock(clock Clock) {
+	if clock == nil {
+		clock = systemClock{}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.clock = clock
+}
+
+// On sets the handler of the emits of the server with the key
+func (c *Client) On(key string, handle ClientHandle) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.handlers[key] = handle
+}
+
+// OnUnhandled sets the handler of the emits without a handler of their key,
+// without one they are replied empty data at once
+func (c *Client) OnUnhandled(handle ClientHandle) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.unhandled = handle
+}
+
+func (c *Client) Off(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.handlers, key)
+}
+
+// Emit sends a message to the handler of the key and waits for its reply.
+// The server replies empty data after 3 seconds when the handler does not write.
+func (c *Client) Emit(ctx context.Context, key string, data []byte) (*Message, error) {
+	return c.emit(ctx, false, key, data)
+}
+
+// LongtimeEmit is Emit without the default reply of the server, the handler replies when it is done
+func (c *Client) LongtimeEmit(ctx context.Context, key string, data []byte) (*Message, error) {
+	return c.emit(ctx, true, key, data)
+}
+
+func (c *Client) emit(ctx context.Context, longtime bool, key string, data []byte) (*Message, error) {
+	msgid := makeMsgId()
+	back := c.await(msgid)
+	defer c.forget(msgid)
+
+	err := c.write(payload{MsgType: PostMessage, MsgID: msgid, Longtime: longtime, Key: key, Data: data})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case p := <-back:
+		return &Message{client: c, payload: p, replied: true}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.closed:
+		return nil, ErrConnectionClosed
+	}
+}
+
+// Ping waits for the pong of the server, it also keeps the connection from the idle timeout
+func (c *Client) Ping(ctx context.Context) error {
+	msgid := makeMsgId()
+	back := c.await(msgid)
+	defer c.forget(msgid)
+
+	frame := append(append([]byte{PingMessage, 0}, msgid...), 0, 0, 0, 0)
+	c.writeLock.Lock()
+	err := c.transport.WriteFrame(frame)
+	c.writeLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-back:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.closed:
+		return ErrConnectionClosed
+	}
+}
+
+// Close closes the connection normally
+func (c *Client) Close() error {
+	return c.transport.Close(CloseNormalClosure, "")
+}
+
+// Done is closed once the connection is gone
+func (c *Client) Done() <-chan struct{} {
+	return c.closed
+}
+
+// Err returns why the connection is gone, a *CloseError when it was closed by either end
+func (c *Client) Err() error {
+	select {
+	case <-c.closed:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+func (c *Client) await(msgid []byte) <-chan payload {
+	back := make(chan payload, 1)
+
+	c.lock.Lock()
+	c.pending[string(msgid)] = back
+	c.lock.Unlock()
+
+	return back
+}
+
+func (c *Client) forget(msgid []byte) {
+	c.lock.Lock()
+	delete(c.pending, string(msgid))
+	c.lock.Unlock()
+}
+
+func (c *Client) settle(p payload) {
+	c.lock.Lock()
+	back, ok := c.pending[string(p.MsgID)]
+	c.lock.Unlock()
+
+	if ok {
+		select {
+		case back <- p:
+		default:
+		}
+	}
+}
+
+// write encodes a frame of the client: its allLength does not include the base
+// and with enzo-v2 it carries the next sequence.
+func (c *Client) write(p payload) error {
+	frame := encodePayload(p, c.features)
+	binary.LittleEndian.PutUint32(frame[12:16], uint32(len(frame)-16))
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if c.features.seq {
+		c.seq++
+		binary.LittleEndian.PutUint64(frame[16:24], c.seq)
+	}
+	return c.transport.WriteFrame(frame)
+}
+
+func (c *Client) read() {
+	for {
+		body, err := c.transport.ReadFrame()
+		if err != nil {
+			c.err = err
+			close(c.closed)
+			return
+		}
+		if len(body) < 16 {
+			continue
+		}
+
+		switch body[0] {
+		case PingMessage:
+			body[0] = PongMessage
+			c.writeLock.Lock()
+			c.transport.WriteFrame(body[:16])
+			c.writeLock.Unlock()
+			continue
+		case PongMessage:
+			c.settle(payload{MsgType: PongMessage, MsgID: body[2:12]})
+			continue
+		}
+
+		p, err := decodeFrame(body, c.features, 0)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		switch p.MsgType {
+		case BackMessage:
+			c.settle(p)
+		case PostMessage, PluginMessage:
+			go c.serve(p)
+		}
+	}
+}
+
+// serve runs the handler of an emit of the server, like the server it replies empty data
+// after 3 seconds, or at once when there is no handler.
+func (c *Client) serve(p payload) {
+	m := &Message{client: c, payload: p}
+
+	c.lock.Lock()
+	handle, ok := c.handlers[p.Key]
+	if !ok {
+		handle = c.unhandled
+	}
+	clock := c.clock
+	c.lock.Unlock()
+
+	if handle == nil {
+		m.Write(nil)
+		return
+	}
+
+	if !p.Longtime {
+		m.lock.Lock()
+		m.timer = clock.AfterFunc(3*time.Second, func() {
+			m.Write(nil)
+		})
+		m.lock.Unlock()
+	}
+
+	handle(m)
+}
+
+func (m *Message) GetKey() string {
+	return m.payload.Key
+}
+
+func (m *Message) GetData() []byte {
+	return m.payload.Data
+}
+
+// Header returns the value of the named header of the message
+func (m *Message) Header(name string) string {
+	return m.payload.Header.Get(name)
+}
+
+// Write replies to an emit of the server, only the first reply is sent
+func (m *Message) Write(data []byte) error {
+	m.lock.Lock()
+	if m.replied {
+		m.lock.Unlock()
+		return nil
+	}
+	m.replied = true
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+	m.lock.Unlock()
+
+	return m.client.write(payload{MsgType: BackMessage, MsgID: m.payload.MsgID, Key: m.payload.Key, Data: data})
+}
@@ -0,0 +1,54 @@
+package enzogo
+
+import (
+	"time"
+)
+
+// Clock runs the timers of Enzo and its plugins: the default reply and the reply timeouts,
+// the retries, the resume grace period, the transfers, the polls and the cluster heartbeat.
+// A fake clock makes them deterministic in tests, see enzotest. The read deadlines of
+// the transports, e.g. the idle timeout, follow the system time.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a timer of a Clock, *time.Timer for the system clock
+type Timer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// SystemClock is the default clock, on the time of the system
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// SetClock replaces the clock before serving, nil restores the system clock
+func (enzo *Enzo) SetClock(c Clock) {
+	if c == nil {
+		c = systemClock{}
+	}
+	enzo.clock = c
+}
+
+// Clock returns the clock of the timers, for the plugins
+func (enzo *Enzo) Clock() Clock {
+	return enzo.clock
+}
+
+// after is time.After on the clock, the timer must be stopped when the wait ends early
+func after(c Clock, d time.Duration) (<-chan struct{}, Timer) {
+	ch := make(chan struct{})
+	return ch, c.AfterFunc(d, func() {
+		close(ch)
+	})
+}
@@ -0,0 +1,139 @@
+package enzogo
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"time"
+)
+
+// close codes of RFC 6455, used by every transport
+const (
+	CloseNormalClosure   = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseAbnormalClosure = 1006
+	ClosePolicyViolation = 1008
+
+	// CloseKicked is the close code of a connection the server does not want back,
+	// the js sdk does not reconnect after it.
+	CloseKicked = 4001
+)
+
+var ErrIdleTimeout = errors.New("idle timeout")
+
+// CloseError is returned by Transport.ReadFrame once the other end closed the transport
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	if e.Text == "" {
+		return "close " + strconv.Itoa(e.Code)
+	}
+	return "close " + strconv.Itoa(e.Code) + ": " + e.Text
+}
+
+// isCloseError reports whether err is a CloseError with one of the codes
+func isCloseError(err error, codes ...int) bool {
+	var ce *CloseError
+	if !errors.As(err, &ce) {
+		return false
+	}
+	for _, code := range codes {
+		if ce.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// DisconnectReason tells why the socket of a connection is gone
+type DisconnectReason struct {
+	// close code received from the client or sent by the server,
+	// CloseAbnormalClosure when the transport failed without a close frame
+	Code int
+	Text string
+	// the read error of a failed socket, ErrIdleTimeout when the client was silent for too long
+	Err error
+	// closed by the server, with Context.Close or Enzo.Stop
+	Server bool
+}
+
+// SetIdleTimeout closes the connections which receive nothing for d, the js sdk pings every few seconds.
+// 0 disables the timeout.
+func (enzo *Enzo) SetIdleTimeout(d time.Duration) {
+	enzo.lock.Lock()
+	defer enzo.lock.Unlock()
+
+	enzo.idleTimeout = d
+}
+
+// DisconnectReason returns why the socket is gone, nil while it is connected
+func (ctx *Context) DisconnectReason() *DisconnectReason {
+	ctx.conn.writeLock.Lock()
+	defer ctx.conn.writeLock.Unlock()
+
+	return ctx.conn.reason
+}
+
+// Close sends a close frame and ends the connection for good, it is not resumable.
+// Use CloseKicked to keep the js sdk from reconnecting.
+func (ctx *Context) Close(code int, text string) error {
+	return ctx.enzo.kick(ctx.conn, code, text)
+}
+
+func (enzo *Enzo) kick(c *connection, code int, text string) error {
+	c.writeLock.Lock()
+	// a connection of another node
+	if c.transport == nil {
+		c.writeLock.Unlock()
+		return ErrConnNotFound
+	}
+	if c.reason != nil && c.reason.Server {
+		c.writeLock.Unlock()
+		return ErrConnectionClosed
+	}
+	c.reason = &DisconnectReason{Code: code, Text: text, Server: true}
+	t, detached := c.transport, c.detached
+	c.writeLock.Unlock()
+
+	enzo.resumeLock.Lock()
+	if c.token != "" && enzo.resumable[c.token] == c {
+		delete(enzo.resumable, c.token)
+	}
+	enzo.resumeLock.Unlock()
+
+	// no socket to close, waiting for a resume
+	if detached {
+		enzo.disconnect(c)
+		return nil
+	}
+
+	// the read loop ends once the transport is closed
+	return t.Close(code, text)
+}
+
+// closeAll closes every connection of this node
+func (enzo *Enzo) closeAll(code int, text string) {
+	enzo.conns.Range(func(_, c interface{}) bool {
+		enzo.kick(c.(*connection), code, text)
+		return true
+	})
+}
+
+// reasonOf makes the reason of a read error
+func reasonOf(err error) *DisconnectReason {
+	var ce *CloseError
+	if errors.As(err, &ce) {
+		return &DisconnectReason{Code: ce.Code, Text: ce.Text}
+	}
+
+	r := &DisconnectReason{Code: CloseAbnormalClosure, Err: err}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		r.Err = ErrIdleTimeout
+	}
+	return r
+}
@@ -0,0 +1,209 @@
+package enzogo
+
+import (
+	"errors"
+	"log"
+)
+
+// kinds of Envelope
+const (
+	EnvelopeBroadcast = "broadcast"
+	EnvelopeRoom      = "room"
+	EnvelopeConn      = "conn"
+)
+
+var ErrConnNotFound = errors.New("connection not found")
+
+// Envelope is a message exchanged between the nodes of a cluster
+type Envelope struct {
+	// the node which published the envelope
+	Node string
+	Kind string
+	// room name, connid or node, depending on Kind
+	Target string
+	// correlates a request emitted to a connection of another node and its reply
+	ID     string
+	Key    string
+	Header Header
+	Data   []byte
+	// error of a reply
+	Error string
+	// the message is a PluginMessage
+	Plugin bool
+}
+
+// Broker relays envelopes between the nodes of a cluster,
+// every envelope published by a node is delivered to the subscribers of all the other nodes.
+type Broker interface {
+	Publish(Envelope) error
+	Subscribe(func(Envelope)) error
+	Close() error
+}
+
+// SetBroker joins the cluster of the broker, so Broadcast, EmitRoom and EmitTo
+// reach the connections of the other nodes too. The previous broker is closed.
+func (enzo *Enzo) SetBroker(b Broker) error {
+	err := b.Subscribe(func(e Envelope) {
+		// not the envelopes of a replaced broker still in flight
+		if enzo.getBroker() == b {
+			enzo.receiveEnvelope(e)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	enzo.lock.Lock()
+	old := enzo.broker
+	enzo.broker = b
+	enzo.stopHeartbeat()
+	enzo.startHeartbeat()
+	enzo.lock.Unlock()
+
+	if old != nil && old != b {
+		return old.Close()
+	}
+	return nil
+}
+
+// getBroker returns the broker, nil out of a cluster
+func (enzo *Enzo) getBroker() Broker {
+	enzo.lock.Lock()
+	defer enzo.lock.Unlock()
+
+	return enzo.broker
+}
+
+// Node returns the id of this node in the cluster
+func (enzo *Enzo) Node() string {
+	return enzo.node
+}
+
+// Broadcast emits a message to every connection
+func (enzo *Enzo) Broadcast(key string, data []byte) error {
+	return enzo.BroadcastHeader(key, nil, data)
+}
+
+// BroadcastHeader is Broadcast with headers, dropped for the clients speaking enzo-v0
+func (enzo *Enzo) BroadcastHeader(key string, header Header, data []byte) error {
+	e := Envelope{Node: enzo.node, Kind: EnvelopeBroadcast, Key: key, Header: header, Data: data}
+	enzo.deliver(e)
+	return enzo.publish(e)
+}
+
+// EmitRoom emits a message to every connection of a room
+func (enzo *Enzo) EmitRoom(room, key string, data []byte) error {
+	return enzo.EmitRoomHeader(room, key, nil, data)
+}
+
+// EmitRoomHeader is EmitRoom with headers, dropped for the clients speaking enzo-v0
+func (enzo *Enzo) EmitRoomHeader(room, key string, header Header, data []byte) error {
+	e := Envelope{Node: enzo.node, Kind: EnvelopeRoom, Target: room, Key: key, Header: header, Data: data}
+	enzo.deliver(e)
+	return enzo.publish(e)
+}
+
+// PluginEmitRoom emits a PluginMessage to every connection of a room
+func (enzo *Enzo) PluginEmitRoom(room, key string, data []byte) error {
+	e := Envelope{Node: enzo.node, Kind: EnvelopeRoom, Target: room, Key: key, Data: data, Plugin: true}
+	enzo.deliver(e)
+	return enzo.publish(e)
+}
+
+// EmitTo emits a message to a connection by its id. A connection of another node is reached
+// through the broker and its reply is routed back, or its ErrTimeout; the callback fails with
+// ErrNodeGone when that node stops sending heartbeats before the reply.
+func (enzo *Enzo) EmitTo(connid, key string, data []byte, cb ...Handle) error {
+	return enzo.emitTo(PostMessage, connid, key, nil, data, cb...)
+}
+
+// EmitToHeader is EmitTo with headers, dropped for the clients speaking enzo-v0
+func (enzo *Enzo) EmitToHeader(connid, key string, header Header, data []byte, cb ...Handle) error {
+	return enzo.emitTo(PostMessage, connid, key, header, data, cb...)
+}
+
+// PluginEmitTo emits a PluginMessage to a connection by its id, see EmitTo
+func (enzo *Enzo) PluginEmitTo(connid, key string, data []byte, cb ...Handle) error {
+	return enzo.emitTo(PluginMessage, connid, key, nil, data, cb...)
+}
+
+func (enzo *Enzo) emitTo(msgType byte, connid, key string, header Header, data []byte, cb ...Handle) error {
+	if c, ok := enzo.conns.Load(connid); ok {
+		ctx := connContext(enzo, c.(*connection))
+		ctx.header = header
+		if msgType == PluginMessage {
+			return ctx.PluginEmit(key, data, cb...)
+		}
+		return ctx.Emit(key, data, cb...)
+	}
+
+	if enzo.getBroker() == nil {
+		return ErrConnNotFound
+	}
+
+	var callback Handle
+
+	if cb == nil {
+		callback = func(ctx *Context) {}
+	} else {
+		callback = cb[0]
+	}
+
+	return enzo.emitRemote(Envelope{Target: connid, Key: key, Header: header, Data: data, Plugin: msgType == PluginMessage}, callback)
+}
+
+func (enzo *Enzo) publish(e Envelope) error {
+	b := enzo.getBroker()
+	if b == nil {
+		return nil
+	}
+	return b.Publish(e)
+}
+
+func (enzo *Enzo) receiveEnvelope(e Envelope) {
+	if e.Node == enzo.node {
+		return
+	}
+	if enzo.route(e) {
+		return
+	}
+	enzo.deliver(e)
+}
+
+// deliver emits an envelope to the matching connections of this node
+func (enzo *Enzo) deliver(e Envelope) {
+	var targets []*connection
+
+	switch e.Kind {
+	case EnvelopeBroadcast:
+		enzo.conns.Range(func(_, c interface{}) bool {
+			targets = append(targets, c.(*connection))
+			return true
+		})
+	case EnvelopeRoom:
+		targets = enzo.roomMembers(e.Target)
+	case EnvelopeConn:
+		if c, ok := enzo.conns.Load(e.Target); ok {
+			targets = append(targets, c.(*connection))
+		}
+	default:
+		log.Println("unknown envelope kind:", e.Kind)
+		return
+	}
+
+	callback := func(ctx *Context) {}
+	if e.Kind == EnvelopeConn && e.ID != "" {
+		callback = func(res *Context) {
+			enzo.replyRemote(e, res)
+		}
+	}
+
+	msgType := PostMessage
+	if e.Plugin {
+		msgType = PluginMessage
+	}
+
+	for _, c := range targets {
+		connContext(enzo, c).writeHeader(msgType, false, nil, e.Key, e.Header, e.Data, callback)
+	}
+}
@@ -0,0 +1,34 @@
+package enzogo
+
+import (
+	"compress/flate"
+	"errors"
+)
+
+// EnableCompression negotiates permessage-deflate with the clients.
+// Messages shorter than threshold bytes are sent uncompressed, since deflating
+// small frames costs more CPU than the bandwidth it saves.
+func (enzo *Enzo) EnableCompression(level, threshold int) error {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return errors.New("invalid compression level")
+	}
+	if threshold < 0 {
+		return errors.New("invalid compression threshold")
+	}
+
+	enzo.lock.Lock()
+	defer enzo.lock.Unlock()
+
+	enzo.upgrader.EnableCompression = true
+	enzo.compressionLevel = level
+	enzo.compressionThreshold = threshold
+	return nil
+}
+
+// DisableCompression stops negotiating permessage-deflate with new clients
+func (enzo *Enzo) DisableCompression() {
+	enzo.lock.Lock()
+	defer enzo.lock.Unlock()
+
+	enzo.upgrader.EnableCompression = false
+}
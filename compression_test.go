@@ -0,0 +1,106 @@
+package enzogo
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func BenchmarkWriteUncompressed(b *testing.B) {
+	benchmarkWrite(b, -1, flate.DefaultCompression)
+}
+
+func BenchmarkWriteCompressed(b *testing.B) {
+	benchmarkWrite(b, 0, flate.DefaultCompression)
+}
+
+func BenchmarkWriteCompressedBestSpeed(b *testing.B) {
+	benchmarkWrite(b, 0, flate.BestSpeed)
+}
+
+// benchmarkWrite measures the frames written by the server to a client reading them as fast as it can
+func benchmarkWrite(b *testing.B, threshold, level int) {
+	// a JSON-like payload, as compressible as the usual messages
+	var buf bytes.Buffer
+	for i := 0; i < 256; i++ {
+		fmt.Fprintf(&buf, `{"id":%d,"name":"user-%x","score":%d,"ok":%t},`, i*7919, i*2654435761, i*i%1000, i%3 == 0)
+	}
+	frame := buf.Bytes()
+
+	conns := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{EnableCompression: threshold >= 0}
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(rw, r, nil)
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	defer srv.Close()
+
+	// counts the bytes of the frames on the wire, as read by the client
+	var wire countingConn
+	dialer := websocket.Dialer{
+		EnableCompression: true,
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			wire.Conn = conn
+			return &wire, err
+		},
+	}
+	client, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Close()
+
+	conn := <-conns
+	defer conn.Close()
+	t := &wsTransport{conn: conn, compressionThreshold: threshold}
+	if threshold >= 0 {
+		conn.SetCompressionLevel(level)
+	}
+
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		for i := 0; i < b.N; i++ {
+			if _, _, err := client.ReadMessage(); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	}()
+
+	atomic.StoreInt64(&wire.read, 0)
+	b.SetBytes(int64(len(frame)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := t.WriteFrame(frame); err != nil {
+			b.Fatal(err)
+		}
+	}
+	<-read
+	b.ReportMetric(float64(atomic.LoadInt64(&wire.read))/float64(b.N), "wire-B/op")
+}
+
+type countingConn struct {
+	net.Conn
+	read int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.read, int64(n))
+	return n, err
+}
@@ -0,0 +1,126 @@
+package enzogo
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connection holds the state shared by every Context of one socket
+type connection struct {
+	// traffic of the socket, accessed atomically and kept first for the 64-bit alignment
+	bytesIn     uint64
+	bytesOut    uint64
+	messagesIn  uint64
+	messagesOut uint64
+
+	id        string
+	transport Transport
+	req       *http.Request
+	writeLock *sync.Mutex
+	protocol  string
+
+	connectedAt time.Time
+
+	// cancelled when the connection is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// cancel functions of the in-flight incoming messages, keyed by message id
+	cancels sync.Map
+
+	// reliable messages waiting for their reply, keyed by message id
+	outbox     map[string]*outgoing
+	outboxLock sync.Mutex
+
+	// incoming chunked transfers by message id, and the totals of the completed ones
+	// for the final chunks sent again, kept for transferTimeout
+	transfers     map[string]*transfer
+	completed     map[string]int64
+	transfersLock sync.Mutex
+
+	// recently received message ids and their replies
+	seen     map[string][]byte
+	seenPrev map[string][]byte
+	seenAt   time.Time
+	seenLock sync.Mutex
+
+	// resume token, see Enzo.EnableResume
+	token string
+	// incremented whenever a resumed socket is attached
+	generation int
+	// the socket is gone, frames are buffered in pending until the client resumes
+	detached bool
+	pending  [][]byte
+	// closed on the next attach
+	wake chan struct{}
+
+	// sequence of the last frame received and sent (enzo-v2)
+	inSeq  uint64
+	outSeq uint64
+	// the resume grace period when the connection was opened, 0 when it is not resumable
+	grace time.Duration
+	// the last sent frames, replayed on resume, and the last sequence sent before the socket was lost
+	sent        [][]byte
+	sentBytes   int
+	detachedSeq uint64
+
+	// joined rooms, guarded by the rooms lock of Enzo
+	rooms map[string]struct{}
+
+	// why the socket is gone, nil while connected
+	reason *DisconnectReason
+}
+
+// features of the protocol spoken on this connection, it must be called with the write lock held
+func (c *connection) features() features {
+	return knownProtocols[c.protocol]
+}
+
+// current returns the transport and the protocol, they are swapped by a resume
+func (c *connection) current() (Transport, string) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	return c.transport, c.protocol
+}
+
+func (c *connection) writeMessage(frame []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	sequenced := c.features().seq && frame[0] != PingMessage && frame[0] != PongMessage
+	if sequenced {
+		frame = c.stamp(frame)
+	}
+
+	if c.detached {
+		// sequenced frames are replayed from the sent frames on resume
+		if sequenced {
+			if !c.replayable() {
+				return ErrConnectionClosed
+			}
+			return nil
+		}
+		if len(c.pending) >= maxPending {
+			return ErrConnectionClosed
+		}
+		c.pending = append(c.pending, frame)
+		return nil
+	}
+
+	return c.writeFrame(frame)
+}
+
+// writeFrame writes to the transport, it must be called with the write lock held.
+func (c *connection) writeFrame(frame []byte) error {
+	if err := c.transport.WriteFrame(frame); err != nil {
+		return err
+	}
+
+	atomic.AddUint64(&c.messagesOut, 1)
+	atomic.AddUint64(&c.bytesOut, uint64(len(frame)))
+	return nil
+}
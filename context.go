@@ -1,8 +1,8 @@
 package enzogo
 
 import (
-	"bytes"
-	"encoding/binary"
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"sync"
@@ -11,54 +11,96 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-func newContext(id string, enzo *Enzo, conn *websocket.Conn, req *http.Request, writeLock *sync.Mutex, payload payload) *Context {
+var (
+	ErrTimeout          = errors.New("timeout")
+	ErrConnectionClosed = errors.New("connection closed")
+)
+
+func newContext(enzo *Enzo, conn *connection, payload payload) *Context {
+	t, _ := conn.current()
 	c := &Context{
-		id:        id,
 		enzo:      enzo,
-		Conn:      conn,
-		writeLock: writeLock,
+		conn:      conn,
+		transport: t,
+		Conn:      socketOf(t),
 		payload:   payload,
 		replied:   false,
 	}
 
-	if conn != nil && !payload.Longtime {
-		c.timer = time.AfterFunc(3*time.Second, func() {
-			if c.replied {
-				return
-			}
+	if payload.MsgType == PostMessage || payload.MsgType == PluginMessage {
+		c.goCtx = conn.track(payload.MsgID)
+	}
 
+	if c.transport != nil && !payload.Longtime {
+		c.lock.Lock()
+		c.timer = enzo.clock.AfterFunc(3*time.Second, func() {
+			c.lock.Lock()
+			replied := c.replied
 			c.timer = nil
+			c.lock.Unlock()
+
+			if replied {
+				return
+			}
 
 			// reply default message
 			c.write(BackMessage, false, c.payload.MsgID, c.payload.Key, nil, func(ctx *Context) {})
 		})
+		c.lock.Unlock()
 	}
 
 	return c
 }
 
 type Context struct {
-	id        string
-	enzo      *Enzo
-	Conn      *websocket.Conn
-	req       *http.Request
-	writeLock *sync.Mutex
-	payload   payload
-	err       error
-	replied   bool
-	timer     *time.Timer
+	enzo *Enzo
+	conn *connection
+	// nil for a context of a connection which is gone
+	transport Transport
+	// Deprecated: the socket of the websocket transport only, nil for the other transports.
+	// Use Transport.
+	Conn    *websocket.Conn
+	payload payload
+	err     error
+	goCtx   context.Context
+
+	// guards the headers sent with the following writes, and the default reply
+	lock    sync.Mutex
+	header  Header
+	replied bool
+	timer   Timer
+
+	transferred  int64
+	transferSize int64
 }
 
 func (ctx *Context) GetPlugin(name string) Plugin {
-	return ctx.enzo.plugins[name]
+	return ctx.enzo.plugin(name)
 }
 
 func (ctx *Context) GetConnid() string {
-	return ctx.id
+	return ctx.conn.id
 }
 
+// GetHttpRequest returns the request which opened the connection, nil when there is none
 func (ctx *Context) GetHttpRequest() *http.Request {
-	return ctx.req
+	return ctx.conn.req
+}
+
+// Transport returns the transport of the connection, nil once the connection is gone
+func (ctx *Context) Transport() Transport {
+	return ctx.transport
+}
+
+// Protocol returns the protocol version negotiated with the client
+func (ctx *Context) Protocol() string {
+	_, protocol := ctx.conn.current()
+	return protocol
+}
+
+// Seq returns the sequence number of the received frame, 0 before enzo-v2
+func (ctx *Context) Seq() uint64 {
+	return ctx.payload.Seq
 }
 
 func (ctx *Context) IsError() bool {
@@ -77,21 +119,87 @@ func (ctx *Context) GetData() []byte {
 	return ctx.payload.Data
 }
 
+// Header returns the value of the named header of the received frame
+func (ctx *Context) Header(name string) string {
+	return ctx.payload.Header.Get(name)
+}
+
+// GetHeader returns all headers of the received frame
+func (ctx *Context) GetHeader() Header {
+	return ctx.payload.Header
+}
+
+// SetHeader sets a header sent with every following Write, Emit and LongtimeEmit of this context.
+// Headers are dropped silently when the client speaks enzo-v0.
+func (ctx *Context) SetHeader(name, value string) {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	if ctx.header == nil {
+		ctx.header = Header{}
+	}
+	ctx.header.Set(name, value)
+}
+
+// headers returns a copy of the headers set with SetHeader
+func (ctx *Context) headers() Header {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	if len(ctx.header) == 0 {
+		return nil
+	}
+	h := make(Header, len(ctx.header))
+	for name, value := range ctx.header {
+		h[name] = value
+	}
+	return h
+}
+
 func (ctx *Context) Write(data []byte) {
-	ctx.replied = true
+	ctx.markReplied()
+
+	ctx.write(BackMessage, false, ctx.payload.MsgID, ctx.payload.Key, data, func(ctx *Context) {})
+}
+
+// markReplied cancels the default reply
+func (ctx *Context) markReplied() {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
 
+	ctx.replied = true
 	if ctx.timer != nil {
 		ctx.timer.Stop()
 	}
+}
 
-	ctx.write(BackMessage, false, ctx.payload.MsgID, ctx.payload.Key, data, func(ctx *Context) {})
+// Progress reports the progress of the operation, usually a longtime one, before its final Write.
+// The client receives percent (0-100) and data correlated to the original message.
+func (ctx *Context) Progress(percent int, data []byte) error {
+	if percent < 0 || percent > 100 {
+		return errors.New("percent out of range")
+	}
+	ctx.lock.Lock()
+	replied := ctx.replied
+	ctx.lock.Unlock()
+	if replied {
+		return errors.New("already replied")
+	}
+
+	var err error
+	ctx.write(ProgressMessage, ctx.payload.Longtime, ctx.payload.MsgID, ctx.payload.Key, append([]byte{byte(percent)}, data...), func(ictx *Context) {
+		err = ictx.Error()
+	})
+	return err
 }
 
-// make message frame
-// * | base: (1+1+10=4=16) | messageType(1) | longtime(1) | messageId(10) | allLength(4) |
-// ? | data: (4+x+4+x=y)   | keyLength(4)   | key(x)      | dataLength(4) | dataBody(x)  |
 func (ctx *Context) write(msgType byte, longtime bool, msgid []byte, key string, data []byte, callback Handle) {
-	if ctx.Conn == nil {
+	ctx.writeHeader(msgType, longtime, msgid, key, ctx.headers(), data, callback)
+}
+
+// writeHeader is write with the headers of this frame only
+func (ctx *Context) writeHeader(msgType byte, longtime bool, msgid []byte, key string, header Header, data []byte, callback Handle) {
+	if ctx.transport == nil {
 		return
 	}
 
@@ -99,98 +207,106 @@ func (ctx *Context) write(msgType byte, longtime bool, msgid []byte, key string,
 		msgid = makeMsgId()
 	}
 
+	if msgType == BackMessage {
+		ctx.conn.release(msgid)
+	}
+
 	if msgType == PongMessage {
-		ctx.writeLock.Lock()
-		ctx.Conn.WriteMessage(websocket.BinaryMessage,
+		ctx.conn.writeMessage(
 			append(
 				append([]byte{msgType, 0}, msgid...),
 				[]byte{0, 0, 0, 0}...,
 			),
 		)
-		ctx.writeLock.Unlock()
 		return
 	}
 
-	allLength := 16
-
-	if len(key) > 0 {
-		// key len + key
-		allLength += 4 + len(key)
-
-		// body len + body
-		allLength += 4 + len(data)
+	_, protocol := ctx.conn.current()
+	frame := encodePayload(payload{
+		MsgType:  msgType,
+		MsgID:    msgid,
+		Longtime: longtime,
+		Key:      key,
+		Header:   header,
+		Data:     data,
+	}, knownProtocols[protocol])
+
+	// a retried message gets the same reply again
+	if msgType == BackMessage {
+		ctx.conn.remember(msgid, frame)
 	}
 
-	var buf bytes.Buffer
-
-	buf.WriteByte(msgType)
-
-	if longtime {
-		buf.WriteByte(0x1)
-	} else {
-		buf.WriteByte(0x0)
+	if msgType == PostMessage || msgType == PluginMessage {
+		callback = ctx.waitBack(msgid, longtime, callback)
 	}
 
-	// msgid
-	buf.Write(msgid)
-
-	// all length
-	al := make([]byte, 4)
-	binary.LittleEndian.PutUint32(al, uint32(allLength))
-	buf.Write(al)
-
-	if len(key) > 0 {
-		// key length
-		kl := make([]byte, 4)
-		binary.LittleEndian.PutUint32(kl, uint32(len(key)))
-		buf.Write(kl)
-
-		// key
-		buf.WriteString(key)
-
-		// data length
-		dl := make([]byte, 4)
-		binary.LittleEndian.PutUint32(dl, uint32(len(data)))
-		buf.Write(dl)
-
-		// data
-		buf.Write(data)
+	err := ctx.conn.writeMessage(frame)
+	if err != nil {
+		log.Println("write message error:", err)
+		callback(ctx.errorContext(err))
+		return
 	}
+}
 
-	if msgType == PostMessage {
-		var timer *time.Timer
-		eventid := bytes2BHex(msgid)
-
-		// wait back
-		handler := ctx.enzo.emitter.Once(eventid, func(ctx *Context) {
-			timer.Stop()
+// waitBack registers the callback for the reply of a PostMessage or a PluginMessage, it is called exactly once:
+// with the reply, or with an error after a timeout (not for longtime messages),
+// a failed write or the close of the connection.
+func (ctx *Context) waitBack(msgid []byte, longtime bool, callback Handle) Handle {
+	var (
+		timer     Timer
+		timerLock sync.Mutex
+		handler   ListenerHandle
+		once      sync.Once
+	)
+	eventid := bytes2BHex(msgid)
+	settled := make(chan struct{})
+
+	settle := func(res *Context) {
+		once.Do(func() {
+			close(settled)
+			timerLock.Lock()
+			if timer != nil {
+				timer.Stop()
+			}
+			timerLock.Unlock()
+			ctx.enzo.emitter.RemoveListener(eventid, handler)
 
-			callback(ctx)
+			callback(res)
 		})
+	}
 
-		timer = time.AfterFunc(6*time.Second, func() {
-			ctx.enzo.emitter.RemoveListener(eventid, handler)
+	// wait back
+	handler = ctx.enzo.emitter.Once(eventid, settle)
+
+	if longtime {
+		go func() {
+			select {
+			case <-settled:
+			case <-ctx.conn.ctx.Done():
+				settle(ctx.errorContext(ErrConnectionClosed))
+			}
+		}()
+	} else {
+		timerLock.Lock()
+		timer = ctx.enzo.clock.AfterFunc(6*time.Second, func() {
+			settle(ctx.errorContext(ErrTimeout))
 		})
+		timerLock.Unlock()
 	}
 
-	ctx.writeLock.Lock()
-	err := ctx.Conn.WriteMessage(websocket.BinaryMessage, buf.Bytes())
-	ctx.writeLock.Unlock()
-	if err != nil {
-		log.Println("write message error:", err)
-		ictx := &Context{
-			id:        ctx.id,
-			enzo:      ctx.enzo,
-			Conn:      ctx.Conn,
-			writeLock: ctx.writeLock,
-			payload:   payload{},
-			err:       err,
-		}
-		callback(ictx)
-		return
-	}
+	return settle
 }
 
+func (ctx *Context) errorContext(err error) *Context {
+	return &Context{
+		enzo:      ctx.enzo,
+		conn:      ctx.conn,
+		transport: ctx.transport,
+		Conn:      ctx.Conn,
+		payload:   payload{},
+		err:       err,
+	}
+}
 func (ctx *Context) Emit(key string, data []byte, cb ...Handle) error {
 	msgid := makeMsgId()
 
@@ -207,6 +323,21 @@ func (ctx *Context) Emit(key string, data []byte, cb ...Handle) error {
 	return nil
 }
 
+// PluginEmit emits a PluginMessage, the key must start with the name of the plugin
+func (ctx *Context) PluginEmit(key string, data []byte, cb ...Handle) error {
+	var callback Handle
+
+	if cb == nil {
+		callback = func(ctx *Context) {}
+	} else {
+		callback = cb[0]
+	}
+
+	ctx.write(PluginMessage, false, nil, key, data, callback)
+
+	return nil
+}
+
 func (ctx *Context) LongtimeEmit(key string, data []byte, cb ...Handle) error {
 	msgid := makeMsgId()
 
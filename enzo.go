@@ -1,12 +1,16 @@
 package enzogo
 
 import (
+	"compress/flate"
 	"context"
 	"crypto/rand"
-	"encoding/binary"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -16,10 +20,18 @@ const (
 
 	PingMessage byte = 0x14
 	PongMessage byte = 0x15
-	_           byte = 0x16 // PluginMessage
+	// PluginMessage is a keyed message addressed to a plugin, the key is "<plugin name>|<action>"
+	PluginMessage byte = 0x16
 
 	PostMessage byte = 0x28
 	BackMessage byte = 0x29
+
+	ChunkMessage    byte = 0x2A
+	ChunkAckMessage byte = 0x2B
+	StreamMessage   byte = 0x2C
+	ProgressMessage byte = 0x2D
+	CancelMessage   byte = 0x2E
+	ResumeMessage   byte = 0x2F
 )
 
 type Handle func(*Context)
@@ -28,7 +40,9 @@ type payload struct {
 	MsgType  byte
 	MsgID    []byte
 	Longtime bool
+	Seq      uint64
 	Key      string
+	Header   Header
 	Data     []byte
 }
 
@@ -41,6 +55,61 @@ type Enzo struct {
 	events         []listener
 	plugins        map[string]Plugin
 	GenerateConnid func(r *http.Request) string
+
+	// installed plugins in the order of installation, see Enzo.Start
+	pluginOrder []string
+	pluginsLock sync.RWMutex
+	started     bool
+	// serializes Use, Uninstall, Start and Stop, whose hooks run without pluginsLock
+	pluginsOp sync.Mutex
+
+	// DeliveryFailed is called when a ReliableEmit is not replied after all attempts,
+	// the context carries the key and data of the message and the last error.
+	DeliveryFailed func(ctx *Context)
+
+	// SequenceGap is called when a frame of the client does not follow the previous one (enzo-v2),
+	// with the expected and the received sequence.
+	SequenceGap func(ctx *Context, expected, got uint64)
+
+	compressionLevel     int
+	compressionThreshold int
+
+	maxTransferSize int64
+
+	retryPolicy RetryPolicy
+
+	// runs the reply timers, see SetClock
+	clock Clock
+
+	idleTimeout time.Duration
+
+	// long-polling transports by session id, see EnablePolling
+	polling bool
+	polls   sync.Map
+
+	// listeners of Serve
+	listeners map[net.Listener]struct{}
+
+	resumeGrace time.Duration
+	resumable   map[string]*connection
+	resumeLock  sync.Mutex
+
+	// live connections by connid, and the members of every room
+	conns     sync.Map
+	rooms     map[string]map[string]*connection
+	roomsLock sync.Mutex
+
+	node   string
+	broker Broker
+	// closed to stop the heartbeat, nil when it does not run
+	heartbeatStop chan struct{}
+
+	// owner node of the connections of the other nodes, the last heartbeat of every node,
+	// and the requests emitted to connections of other nodes
+	owners      map[string]string
+	nodes       map[string]time.Time
+	pending     map[string]*remoteCall
+	clusterLock sync.Mutex
 }
 
 func New() *Enzo {
@@ -49,13 +118,35 @@ func New() *Enzo {
 			CheckOrigin:     func(r *http.Request) bool { return true },
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
-			Subprotocols:    []string{"enzo-v0"},
+			Subprotocols:    []string{ProtocolV2, ProtocolV1, ProtocolV0},
 		},
 		emitter:        newEmitter(),
 		lock:           sync.Mutex{},
 		events:         []listener{},
 		plugins:        map[string]Plugin{},
 		GenerateConnid: DefaultGenerateConnid,
+
+		compressionLevel:     flate.DefaultCompression,
+		compressionThreshold: 1024,
+
+		maxTransferSize: 64 << 20,
+
+		retryPolicy: DefaultRetryPolicy,
+
+		clock: systemClock{},
+
+		listeners: map[net.Listener]struct{}{},
+
+		resumable: map[string]*connection{},
+
+		conns: sync.Map{},
+		rooms: map[string]map[string]*connection{},
+
+		node: bytes2BHex(makeMsgId()),
+
+		owners:  map[string]string{},
+		nodes:   map[string]time.Time{},
+		pending: map[string]*remoteCall{},
 	}
 }
 
@@ -69,59 +160,136 @@ func DefaultGenerateConnid(r *http.Request) string {
 var _ http.Handler = (*Enzo)(nil)
 
 func (enzo *Enzo) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
-	conn, err := enzo.upgrader.Upgrade(rw, r, nil)
+	// the settings may change while serving, see EnableCompression and EnablePolling
+	enzo.lock.Lock()
+	polling := enzo.polling
+	upgrader := enzo.upgrader
+	level, threshold := enzo.compressionLevel, enzo.compressionThreshold
+	enzo.lock.Unlock()
+
+	if polling && r.URL.Query().Get(transportParam) == "poll" {
+		enzo.servePoll(rw, r)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(rw, r, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	// generate an id
-	id := enzo.GenerateConnid(r)
-
-	request := r.Clone(context.Background())
-	writeLock := new(sync.Mutex)
-	defer func() {
-		writeLock = nil
-	}()
-
-	enzo.emitter.Emit("connect", &Context{
-		id:        id,
-		enzo:      enzo,
-		Conn:      conn,
-		writeLock: writeLock,
-		req:       request,
-	})
-	defer enzo.emitter.Emit("disconnect", &Context{
-		id:        id,
-		enzo:      enzo,
-		Conn:      nil,
-		writeLock: writeLock,
-		req:       request,
-	})
+	protocol, ok := enzo.negotiateProtocol(conn, r)
+	if !ok {
+		log.Println("unsupported protocol:", websocket.Subprotocols(r))
+		rejectProtocol(conn)
+		return
+	}
+
+	t := &wsTransport{conn: conn, compressionThreshold: -1}
+	if upgrader.EnableCompression {
+		conn.SetCompressionLevel(level)
+		t.compressionThreshold = threshold
+	}
+
+	enzo.serve(t, r, protocol)
+}
+
+// serve runs a connection over the transport until it is closed,
+// r is the request which opened the transport, nil when there is none.
+func (enzo *Enzo) serve(t Transport, r *http.Request, protocol string) {
+	resumed := false
+
+	enzo.lock.Lock()
+	grace := enzo.resumeGrace
+	enzo.lock.Unlock()
+
+	var c *connection
+	var req *http.Request
+	if r != nil {
+		if grace > 0 {
+			c = enzo.reclaim(r.URL.Query().Get(resumeParam))
+		}
+		req = r.Clone(context.Background())
+	}
+	if c != nil {
+		resumed = true
+		// without the last sequence received only the frames written while away are sent
+		lastSeq, err := strconv.ParseUint(r.URL.Query().Get(resumeSeqParam), 10, 64)
+		if old := c.attach(t, protocol, lastSeq, err == nil); old != nil {
+			old.Close(CloseNormalClosure, "replaced")
+		}
+	} else {
+		c = &connection{
+			// generate an id
+			id:        enzo.GenerateConnid(r),
+			transport: t,
+			req:       req,
+			writeLock: new(sync.Mutex),
+			protocol:  protocol,
+			wake:      make(chan struct{}),
+			grace:     grace,
+
+			connectedAt: enzo.clock.Now(),
+		}
+		c.ctx, c.cancel = context.WithCancel(context.Background())
+		enzo.conns.Store(c.id, c)
+		enzo.announce(EnvelopeOwn, c.id)
+	}
+
+	c.writeLock.Lock()
+	gen := c.generation
+	c.writeLock.Unlock()
+
+	if c.grace > 0 {
+		enzo.issueToken(c)
+	}
+
+	if resumed {
+		enzo.emitter.Emit("resume", connContext(enzo, c))
+	} else {
+		enzo.connected(connContext(enzo, c))
+	}
 
 	for {
-		_, p, err := conn.ReadMessage()
+		// read for every frame, the timeout may change while serving
+		enzo.lock.Lock()
+		idle := enzo.idleTimeout
+		enzo.lock.Unlock()
+		if idle > 0 {
+			t.SetReadDeadline(time.Now().Add(idle))
+		}
+
+		p, err := t.ReadFrame()
 		if err != nil {
 			log.Println("read an error: ", err)
+			if !isCloseError(err, CloseNormalClosure, CloseGoingAway) {
+				enzo.failed(connContext(enzo, c), err)
+			}
+			enzo.closed(c, gen, err)
 			return
 		}
 
+		atomic.AddUint64(&c.messagesIn, 1)
+		atomic.AddUint64(&c.bytesIn, uint64(len(p)))
+
+		// the order is only known here, before the dispatch
+		if !enzo.checkSeq(c, knownProtocols[protocol], p) {
+			continue
+		}
+
 		// parse
 		go func(body []byte) {
 			if body == nil {
 				return
 			}
 			if len(body) < 16 {
-				// TODO
-				// mismatched body length
-				log.Println("mismatched body length")
+				log.Println(errMismatchedLength)
+				enzo.failed(connContext(enzo, c), errMismatchedLength)
 				return
 			}
 			if body[0] == PingMessage {
 				body[0] = PongMessage
-				writeLock.Lock()
-				conn.WriteMessage(websocket.BinaryMessage, body)
-				writeLock.Unlock()
+				c.writeMessage(body)
 				enzo.emitter.Emit("ping")
 				return
 			}
@@ -130,69 +298,49 @@ func (enzo *Enzo) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			res := payload{}
-
-			offset := 0
-
-			// message type
-			res.MsgType = body[0]
-			offset += 1
-
-			// longtime
-			res.Longtime = body[offset] == 0x1
-			offset += 1
-
-			// msgid
-			res.MsgID = body[offset : offset+10]
-			msgid := bytes2BHex(res.MsgID)
-			offset += 10
+			res, err := decodePayload(body, knownProtocols[protocol])
+			if err != nil {
+				log.Println(err)
+				ctx := connContext(enzo, c)
+				ctx.payload = res
+				enzo.failed(ctx, err)
+				return
+			}
 
-			// all len
-			_allLength := body[offset : offset+4]
-			allLength := int(binary.LittleEndian.Uint32(_allLength))
-			offset += 4
+			switch res.MsgType {
+			case BackMessage:
+				enzo.emitter.Emit(bytes2BHex(res.MsgID), newContext(enzo, c, res))
+				return
+			case ChunkMessage:
+				enzo.receiveChunk(c, res)
+				return
+			case CancelMessage:
+				c.release(res.MsgID)
+				return
+			case ChunkAckMessage:
+				ctx := connContext(enzo, c)
+				ctx.payload = res
+				enzo.emitter.Emit(chunkAckEvent(res.MsgID), ctx)
+				return
+			}
 
 			// no key & data
-			if offset == 16 && allLength == 0 {
-				//
-				if res.MsgType == BackMessage {
-					enzo.emitter.Emit(msgid, newContext(id, enzo, conn, request, writeLock, res))
-					return
-				}
+			if res.Key == "" {
 				// ! unhandled
 				return
 			}
 
-			if len(body)-16 != allLength {
-				// TODO
-				// mismatched body length
-				log.Println("mismatched body length")
-				return
+			// a retry of a reliable message already received
+			if res.Header.Get(reliableHeader) != "" {
+				if dup, reply := c.received(res.MsgID, enzo.clock.Now()); dup {
+					if reply != nil {
+						c.writeMessage(reply)
+					}
+					return
+				}
 			}
 
-			// key len
-			_keyLength := body[offset : offset+4]
-			keyLength := int(binary.LittleEndian.Uint32(_keyLength))
-			offset += 4
-
-			// key
-			_key := body[offset : offset+keyLength]
-			res.Key = string(_key)
-			offset += keyLength
-
-			// data
-			_dataLength := body[offset : offset+4]
-			dataLength := int(binary.LittleEndian.Uint32(_dataLength))
-			offset += 4
-
-			res.Data = body[offset : offset+dataLength]
-			offset += dataLength
-
-			if res.MsgType == BackMessage {
-				enzo.emitter.Emit(msgid, newContext(id, enzo, conn, request, writeLock, res))
-				return
-			}
-			enzo.emitter.Emit(res.Key, newContext(id, enzo, conn, request, writeLock, res))
+			enzo.dispatch(newContext(enzo, c, res))
 		}(p)
 	}
 }
@@ -231,18 +379,6 @@ func (enzo *Enzo) Off(key string) error {
 	return nil
 }
 
-func (enzo *Enzo) Use(plugins ...Plugin) {
-	if plugins == nil {
-		return
-	}
-
-	for _, p := range plugins {
-		log.Println("register plugin:", p.Name())
-		p.Install(enzo)
-		enzo.plugins[p.Name()] = p
-	}
-}
-
 type listener struct {
 	key    string
 	handle ListenerHandle
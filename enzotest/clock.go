@@ -0,0 +1,102 @@
+package enzotest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	enzogo "github.com/cuipeiyu/enzo.go"
+)
+
+// Clock is a fake clock, its time only moves with Advance
+type Clock struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers []*timer
+}
+
+var _ enzogo.Clock = (*Clock)(nil)
+
+// NewClock returns a clock at a fixed time
+func NewClock() *Clock {
+	return &Clock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *Clock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+func (c *Clock) AfterFunc(d time.Duration, f func()) enzogo.Timer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	t := &timer{clock: c, f: f, when: c.now.Add(d)}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the time by d and runs the timers due meanwhile in order, it returns once
+// they are done. Most of them only start a write or a handler in a goroutine, so their
+// effects land after: wait for them with Call.Wait, Client.Expect or Client.Done.
+func (c *Clock) Advance(d time.Duration) {
+	c.lock.Lock()
+	end := c.now.Add(d)
+	c.lock.Unlock()
+
+	for {
+		c.lock.Lock()
+		sort.SliceStable(c.timers, func(i, j int) bool {
+			return c.timers[i].when.Before(c.timers[j].when)
+		})
+		if len(c.timers) == 0 || c.timers[0].when.After(end) {
+			c.now = end
+			c.lock.Unlock()
+			return
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.when.After(c.now) {
+			c.now = t.when
+		}
+		c.lock.Unlock()
+
+		t.f()
+	}
+}
+
+// remove takes the timer out of the pending ones, it must be called with the lock held
+func (c *Clock) remove(t *timer) bool {
+	for i, p := range c.timers {
+		if p == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type timer struct {
+	clock *Clock
+	f     func()
+	when  time.Time
+}
+
+func (t *timer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	return t.clock.remove(t)
+}
+
+func (t *timer) Reset(d time.Duration) bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	active := t.clock.remove(t)
+	t.when = t.clock.now.Add(d)
+	t.clock.timers = append(t.clock.timers, t)
+	return active
+}
@@ -0,0 +1,318 @@
+// Package enzotest runs the handlers of an Enzo in memory for tests: clients connect over
+// pipes, and a fake clock drives the default replies and the reply timeouts.
+//
+//	srv := enzotest.NewServer(enzo)
+//	defer srv.Close()
+//
+//	c, err := srv.Connect()
+//	reply, err := c.Emit("hello", []byte("world"))
+//
+//	srv.Clock.Advance(6 * time.Second)
+//	m := c.Expect(t, "notice")
+package enzotest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	enzogo "github.com/cuipeiyu/enzo.go"
+)
+
+// Wait bounds, in real time, the waits of Emit and Expect, so a broken test fails instead of hanging
+var Wait = 5 * time.Second
+
+// header of the requests of the clients, to learn their connids
+const clientHeader = "Enzotest-Client"
+
+type Server struct {
+	Enzo  *enzogo.Enzo
+	Clock *Clock
+
+	lock    sync.Mutex
+	next    int
+	connids map[string]chan string
+	clients []*Client
+}
+
+// NewServer serves the enzo to in-memory clients with the fake clock of every timer,
+// give it to the memory storage of the sessions with memory.NewWithClock.
+// It wraps the GenerateConnid of the enzo, set yours before.
+func NewServer(enzo *enzogo.Enzo) *Server {
+	s := &Server{
+		Enzo:    enzo,
+		Clock:   NewClock(),
+		connids: map[string]chan string{},
+	}
+
+	enzo.SetClock(s.Clock)
+
+	generate := enzo.GenerateConnid
+	enzo.GenerateConnid = func(r *http.Request) string {
+		connid := generate(r)
+		if r == nil {
+			return connid
+		}
+
+		s.lock.Lock()
+		ch, ok := s.connids[r.Header.Get(clientHeader)]
+		s.lock.Unlock()
+		if ok {
+			ch <- connid
+		}
+		return connid
+	}
+
+	return s
+}
+
+// Connect connects a client speaking enzo-v2
+func (s *Server) Connect() (*Client, error) {
+	return s.ConnectWith(enzogo.ProtocolV2, nil)
+}
+
+// ConnectWith connects a client speaking the protocol, r is the request seen by the handlers,
+// e.g. with the cookies of a session. It is nil for a plain GET.
+// It fails when the server does not accept the protocol.
+func (s *Server) ConnectWith(protocol string, r *http.Request) (*Client, error) {
+	if r == nil {
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+	}
+
+	s.lock.Lock()
+	s.next++
+	id := strconv.Itoa(s.next)
+	ch := make(chan string, 1)
+	s.connids[id] = ch
+	s.lock.Unlock()
+
+	r = r.Clone(context.Background())
+	r.Header.Set(clientHeader, id)
+
+	server, client := enzogo.NewPipe()
+	g := &gate{Transport: client, open: make(chan struct{})}
+	close(g.open)
+
+	c := &Client{
+		Client: enzogo.NewClient(g, protocol),
+		gate:   g,
+		ready:  make(chan struct{}, 1),
+	}
+	c.SetClock(s.Clock)
+	c.OnUnhandled(c.record)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Enzo.ServeTransport(server, protocol, r)
+	}()
+
+	var err error
+	select {
+	case c.connid = <-ch:
+	case err = <-served:
+		// connected and gone already
+		select {
+		case c.connid = <-ch:
+			err = nil
+		default:
+			if err == nil {
+				err = enzogo.ErrConnectionClosed
+			}
+		}
+	}
+
+	s.lock.Lock()
+	delete(s.connids, id)
+	if err == nil {
+		s.clients = append(s.clients, c)
+	}
+	s.lock.Unlock()
+
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close closes the clients
+func (s *Server) Close() {
+	s.lock.Lock()
+	clients := s.clients
+	s.clients = nil
+	s.lock.Unlock()
+
+	for _, c := range clients {
+		c.Unstall()
+		c.Close()
+	}
+}
+
+// Client is an in-memory client. The emits of the server without a handler set with On are
+// recorded for Expect, they are replied with Message.Write or empty data after 3 seconds of the clock.
+type Client struct {
+	*enzogo.Client
+
+	connid string
+	gate   *gate
+
+	lock     sync.Mutex
+	received []*enzogo.Message
+	ready    chan struct{}
+}
+
+// Connid returns the connid given by the server
+func (c *Client) Connid() string {
+	return c.connid
+}
+
+// Emit emits to the handler of the key and waits for its reply
+func (c *Client) Emit(key string, data []byte) ([]byte, error) {
+	return c.EmitAsync(key, data).Wait()
+}
+
+// EmitAsync emits without waiting, e.g. to advance the clock until the default reply
+func (c *Client) EmitAsync(key string, data []byte) *Call {
+	call := &Call{done: make(chan struct{})}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), Wait)
+		defer cancel()
+
+		m, err := c.Client.Emit(ctx, key, data)
+		if err == nil {
+			call.data = m.GetData()
+		}
+		call.err = err
+		close(call.done)
+	}()
+	return call
+}
+
+// Expect returns the next recorded emit of the server, the test fails when it has another key
+// or when none comes
+func (c *Client) Expect(t testing.TB, key string) *enzogo.Message {
+	t.Helper()
+
+	timeout := time.NewTimer(Wait)
+	defer timeout.Stop()
+
+	for {
+		c.lock.Lock()
+		if len(c.received) > 0 {
+			m := c.received[0]
+			c.received = c.received[1:]
+			c.lock.Unlock()
+
+			if m.GetKey() != key {
+				t.Fatalf("enzotest: expected an emit of %q, got %q", key, m.GetKey())
+			}
+			return m
+		}
+		c.lock.Unlock()
+
+		select {
+		case <-c.ready:
+		case <-timeout.C:
+			t.Fatalf("enzotest: no emit of %q", key)
+			return nil
+		}
+	}
+}
+
+// Received returns the recorded emits not taken by Expect yet
+func (c *Client) Received() []*enzogo.Message {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([]*enzogo.Message(nil), c.received...)
+}
+
+func (c *Client) record(m *enzogo.Message) {
+	c.lock.Lock()
+	c.received = append(c.received, m)
+	c.lock.Unlock()
+
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Drop cuts the connection without a close frame, like a lost network
+func (c *Client) Drop() {
+	c.Unstall()
+	c.gate.Close(enzogo.CloseAbnormalClosure, "")
+	<-c.Done()
+}
+
+// Disconnect closes the connection normally and waits until it is gone
+func (c *Client) Disconnect() {
+	c.Unstall()
+	c.Close()
+	<-c.Done()
+}
+
+// Stall holds the frames of the server received from now on, like a slow client:
+// its writes block once 64 frames are waiting, until Unstall
+func (c *Client) Stall() {
+	c.gate.lock.Lock()
+	defer c.gate.lock.Unlock()
+
+	select {
+	case <-c.gate.open:
+		c.gate.open = make(chan struct{})
+	default:
+	}
+}
+
+func (c *Client) Unstall() {
+	c.gate.lock.Lock()
+	defer c.gate.lock.Unlock()
+
+	select {
+	case <-c.gate.open:
+	default:
+		close(c.gate.open)
+	}
+}
+
+// Call is an emit waiting for its reply
+type Call struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// Done is closed once the reply or an error is there
+func (call *Call) Done() <-chan struct{} {
+	return call.done
+}
+
+// Wait returns the data of the reply
+func (call *Call) Wait() ([]byte, error) {
+	<-call.done
+	return call.data, call.err
+}
+
+// gate holds the reads of a stalled client
+type gate struct {
+	enzogo.Transport
+
+	lock sync.Mutex
+	open chan struct{}
+}
+
+func (g *gate) ReadFrame() ([]byte, error) {
+	p, err := g.Transport.ReadFrame()
+
+	g.lock.Lock()
+	open := g.open
+	g.lock.Unlock()
+
+	<-open
+	return p, err
+}
@@ -0,0 +1,145 @@
+package enzotest_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	enzogo "github.com/cuipeiyu/enzo.go"
+	"github.com/cuipeiyu/enzo.go/enzotest"
+)
+
+func TestEmitReply(t *testing.T) {
+	enzo := enzogo.New()
+	enzo.On("echo", func(ctx *enzogo.Context) {
+		ctx.Write(ctx.GetData())
+	})
+
+	srv := enzotest.NewServer(enzo)
+	defer srv.Close()
+
+	c, err := srv.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := c.Emit("echo", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "hello" {
+		t.Fatalf("reply %q, want %q", reply, "hello")
+	}
+}
+
+func TestExpect(t *testing.T) {
+	enzo := enzogo.New()
+	replied := make(chan []byte, 1)
+	enzo.On("subscribe", func(ctx *enzogo.Context) {
+		ctx.Write(nil)
+		ctx.Emit("notice", []byte("news"), func(res *enzogo.Context) {
+			replied <- res.GetData()
+		})
+	})
+
+	srv := enzotest.NewServer(enzo)
+	defer srv.Close()
+
+	c, err := srv.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Emit("subscribe", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	m := c.Expect(t, "notice")
+	if string(m.GetData()) != "news" {
+		t.Fatalf("notice %q, want %q", m.GetData(), "news")
+	}
+	m.Write([]byte("thanks"))
+
+	if got := <-replied; string(got) != "thanks" {
+		t.Fatalf("reply %q, want %q", got, "thanks")
+	}
+}
+
+func TestDefaultReply(t *testing.T) {
+	enzo := enzogo.New()
+	enzo.On("silent", func(ctx *enzogo.Context) {})
+
+	srv := enzotest.NewServer(enzo)
+	defer srv.Close()
+
+	c, err := srv.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := c.EmitAsync("silent", nil)
+
+	// the handler is served before the clock moves
+	select {
+	case <-call.Done():
+		t.Fatal("replied before the default reply")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	srv.Clock.Advance(2 * time.Second)
+	select {
+	case <-call.Done():
+		t.Fatal("replied before 3 seconds")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	srv.Clock.Advance(time.Second)
+	reply, err := call.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply) != 0 {
+		t.Fatalf("default reply %q, want empty", reply)
+	}
+}
+
+func TestReplyTimeout(t *testing.T) {
+	enzo := enzogo.New()
+
+	srv := enzotest.NewServer(enzo)
+	defer srv.Close()
+
+	c, err := srv.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the notice is never read, so not even replied by default
+	c.Stall()
+
+	failed := make(chan error, 1)
+	err = enzo.EmitTo(c.Connid(), "notice", nil, func(res *enzogo.Context) {
+		failed <- res.Error()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		srv.Clock.Advance(3 * time.Second)
+	}
+	if err := <-failed; !errors.Is(err, enzogo.ErrTimeout) {
+		t.Fatalf("error %v, want %v", err, enzogo.ErrTimeout)
+	}
+}
+
+func TestUnsupportedProtocol(t *testing.T) {
+	enzo := enzogo.New()
+	enzo.SetProtocols(enzogo.ProtocolV0)
+
+	srv := enzotest.NewServer(enzo)
+	defer srv.Close()
+
+	if _, err := srv.ConnectWith(enzogo.ProtocolV2, nil); err == nil {
+		t.Fatal("connected with an unsupported protocol")
+	}
+}
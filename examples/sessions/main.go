@@ -12,11 +12,19 @@ import (
 func main() {
 	enzo := enzogo.New()
 
-	enzo.Use(
+	err := enzo.Use(
 		sessions.New(func() sessions.Storage {
 			return memory.New()
 		}),
 	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := enzo.Start(); err != nil {
+		log.Fatal(err)
+	}
+	defer enzo.Stop()
 
 	enzo.On("connect", func(ctx *enzogo.Context) {
 		sess, err := sessions.Load(ctx)
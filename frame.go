@@ -0,0 +1,244 @@
+package enzogo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errMismatchedLength = errors.New("mismatched body length")
+
+// Header carries the optional key/value metadata of a frame (enzo-v1 and later)
+type Header map[string]string
+
+func (h Header) Get(name string) string {
+	if h == nil {
+		return ""
+	}
+	return h[name]
+}
+
+func (h Header) Set(name, value string) {
+	h[name] = value
+}
+
+func (h Header) Del(name string) {
+	delete(h, name)
+}
+
+// make message frame
+// * | base: (1+1+10+4=16) | messageType(1)  | longtime(1) | messageId(10) | allLength(4) |
+// # | seq:  (8)           | sequence(8)     |                                               (since enzo-v2)
+// ! | head: (4+x)         | headerLength(4) | header(x)   |                                  (since enzo-v1)
+// ? | data: (4+x+4+x=y)   | keyLength(4)    | key(x)      | dataLength(4) | dataBody(x)  |
+//
+// every header entry is encoded as | nameLength(4) | name(x) | valueLength(4) | value(x) |
+// the sequence is left zero here, it is set by connection.writeMessage in the order of the writes.
+func encodePayload(p payload, f features) []byte {
+	withHeader := f.header
+	hasBody := len(p.Key) > 0 || (withHeader && len(p.Header) > 0)
+
+	var head []byte
+	if withHeader && hasBody {
+		head = encodeHeader(p.Header)
+	}
+
+	allLength := 16
+
+	if f.seq {
+		allLength += 8
+	}
+
+	if hasBody {
+		if withHeader {
+			// header len + header
+			allLength += 4 + len(head)
+		}
+
+		// key len + key
+		allLength += 4 + len(p.Key)
+
+		// body len + body
+		allLength += 4 + len(p.Data)
+	}
+
+	var buf bytes.Buffer
+
+	buf.WriteByte(p.MsgType)
+
+	if p.Longtime {
+		buf.WriteByte(0x1)
+	} else {
+		buf.WriteByte(0x0)
+	}
+
+	// msgid
+	buf.Write(p.MsgID)
+
+	// all length
+	buf.Write(uint32Bytes(allLength))
+
+	if f.seq {
+		buf.Write(make([]byte, 8))
+	}
+
+	if hasBody {
+		if withHeader {
+			// header length
+			buf.Write(uint32Bytes(len(head)))
+
+			// header
+			buf.Write(head)
+		}
+
+		// key length
+		buf.Write(uint32Bytes(len(p.Key)))
+
+		// key
+		buf.WriteString(p.Key)
+
+		// data length
+		buf.Write(uint32Bytes(len(p.Data)))
+
+		// data
+		buf.Write(p.Data)
+	}
+
+	return buf.Bytes()
+}
+
+// decodePayload parses a frame sent by the client,
+// the allLength of a client frame does not include the base.
+func decodePayload(body []byte, f features) (payload, error) {
+	return decodeFrame(body, f, 16)
+}
+
+// decodeFrame parses a frame whose allLength does not include base bytes,
+// 16 for the frames of a client and 0 for the frames of the server.
+func decodeFrame(body []byte, f features, base int) (payload, error) {
+	res := payload{}
+
+	if len(body) < 16 {
+		return res, errMismatchedLength
+	}
+
+	offset := 0
+
+	// message type
+	res.MsgType = body[0]
+	offset += 1
+
+	// longtime
+	res.Longtime = body[offset] == 0x1
+	offset += 1
+
+	// msgid
+	res.MsgID = body[offset : offset+10]
+	offset += 10
+
+	// all len
+	allLength := int(binary.LittleEndian.Uint32(body[offset : offset+4]))
+	offset += 4
+
+	if len(body)-base != allLength {
+		return res, errMismatchedLength
+	}
+
+	if f.seq {
+		if len(body) < 24 {
+			return res, errMismatchedLength
+		}
+		res.Seq = binary.LittleEndian.Uint64(body[offset : offset+8])
+		offset += 8
+	}
+
+	// no key & data
+	if offset == len(body) {
+		return res, nil
+	}
+
+	if f.header {
+		// header
+		head, err := readBlock(body, &offset)
+		if err != nil {
+			return res, err
+		}
+		res.Header, err = decodeHeader(head)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	// key
+	key, err := readBlock(body, &offset)
+	if err != nil {
+		return res, err
+	}
+	res.Key = string(key)
+
+	// data
+	res.Data, err = readBlock(body, &offset)
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+func encodeHeader(h Header) []byte {
+	var buf bytes.Buffer
+
+	for name, value := range h {
+		buf.Write(uint32Bytes(len(name)))
+		buf.WriteString(name)
+		buf.Write(uint32Bytes(len(value)))
+		buf.WriteString(value)
+	}
+
+	return buf.Bytes()
+}
+
+func decodeHeader(raw []byte) (Header, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	h := Header{}
+	offset := 0
+	for offset < len(raw) {
+		name, err := readBlock(raw, &offset)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readBlock(raw, &offset)
+		if err != nil {
+			return nil, err
+		}
+		h[string(name)] = string(value)
+	}
+
+	return h, nil
+}
+
+// readBlock reads a | length(4) | body(x) | block and moves the offset
+func readBlock(b []byte, offset *int) ([]byte, error) {
+	if len(b) < *offset+4 {
+		return nil, errMismatchedLength
+	}
+	l := int(binary.LittleEndian.Uint32(b[*offset : *offset+4]))
+	*offset += 4
+
+	if l < 0 || len(b) < *offset+l {
+		return nil, errMismatchedLength
+	}
+	block := b[*offset : *offset+l]
+	*offset += l
+
+	return block, nil
+}
+
+func uint32Bytes(n int) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(n))
+	return b
+}
@@ -0,0 +1,62 @@
+// Package httpemit emits to the connections of an Enzo on behalf of an http request,
+// for the admin and bridge handlers.
+package httpemit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	enzogo "github.com/cuipeiyu/enzo.go"
+)
+
+var ErrTooLarge = errors.New("body too large")
+
+// ReadBody reads a body of at most limit bytes, it fails with ErrTooLarge when there is more
+func ReadBody(r *http.Request, limit int) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > limit {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
+
+// Emit emits the data to the connection and waits for the reply, or until the request is done
+func Emit(r *http.Request, enzo *enzogo.Enzo, connid, key string, data []byte) ([]byte, error) {
+	done := make(chan *enzogo.Context, 1)
+	err := enzo.EmitTo(connid, key, data, func(ctx *enzogo.Context) {
+		done <- ctx
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case res := <-done:
+		if res.IsError() {
+			return nil, res.Error()
+		}
+		return res.GetData(), nil
+	case <-r.Context().Done():
+		return nil, r.Context().Err()
+	}
+}
+
+// Status returns the http status of an error of EmitTo or Emit
+func Status(err error) int {
+	switch {
+	case errors.Is(err, enzogo.ErrConnNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, enzogo.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		// the client is gone, nobody reads it
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadGateway
+	}
+}
@@ -1,8 +1,22 @@
 import EventEmitter from 'eventemitter3';
+import { PollingSocket } from './polling';
 
 export declare interface Plugin {
   readonly pluginName: string;
-  install: (a1: Enzo, a2: messageType) => void;
+  install: (a1: Enzo, a2: PluginChannel) => void;
+}
+
+/** the PluginMessage frames exchanged by a plugin and its server side, keyed by `${pluginName}|${action}` */
+export declare interface PluginChannel {
+  readonly messageType: messageType;
+
+  /** listen the messages of an action sent by the plugin of the server */
+  on(action: string, handle: (ctx: Context) => void): void;
+
+  off(action: string, handle?: (ctx: Context) => void): void;
+
+  /** send a message to the plugin of the server and wait for its reply */
+  emit(action: string, data: Uint8Array, callback: (e: Context | Error) => void): void;
 }
 
 export declare interface Options {
@@ -13,14 +27,47 @@ export declare interface Options {
 
   /** Automatically try to reconnect when disconnected. default: true */
   alwaysReconnect?: boolean;
+
+  /** The protocol versions offered to the server, in order of preference. default: protocols */
+  protocols?: string[];
+
+  /** The transports tried in order, 'polling' is used when the websocket can not be opened. default: transports */
+  transports?: string[];
+
+  /** Whether to reconnect after the server closed the socket. default: shouldReconnect */
+  shouldReconnect?: (code: number, reason: string) => boolean;
 }
 
+export const protocols = ['enzo-v2', 'enzo-v1', 'enzo-v0'];
+
+export const transports = ['websocket', 'polling'];
+
 export const defaults: Options = {
   address: '',
   autoConnect: true,
   alwaysReconnect: true,
+  protocols,
+  transports,
+  shouldReconnect,
 };
 
+/** close code sent by the server when none of the offered protocols is supported */
+const CloseProtocolError = 1002;
+
+/** close code of a socket replaced by a new one, the server keeps the connection for resumption */
+const CloseReconnect = 4000;
+
+/** close code of a connection the server does not want back */
+export const CloseKicked = 4001;
+
+/** close code of a socket closed for breaking a policy of the server */
+const ClosePolicyViolation = 1008;
+
+/** the default of Options.shouldReconnect: not after a kick */
+export function shouldReconnect(code: number, _reason: string): boolean {
+  return code !== CloseKicked && code !== ClosePolicyViolation && code !== CloseProtocolError;
+}
+
 export enum messageType {
   CloseMessage = 0x01,
 
@@ -30,13 +77,61 @@ export enum messageType {
 
   PostMessage = 0x28,
   BackMessage = 0x29,
+
+  ChunkMessage = 0x2A,
+  ChunkAckMessage = 0x2B,
+  StreamMessage = 0x2C,
+  ProgressMessage = 0x2D,
+  CancelMessage = 0x2E,
+  ResumeMessage = 0x2F,
+}
+
+/** status of a StreamMessage, the first byte of its data */
+enum streamStatus {
+  data = 0x00,
+  end = 0x01,
+  error = 0x02,
 }
 
+/** size of the data carried by one ChunkMessage */
+const chunkSize = 64 * 1024;
+
+/** header of the messages sent again until they are replied, their retries are dropped */
+const reliableHeader = 'Enzo-Reliable';
+
+/** ids of received reliable messages kept in one generation */
+const maxSeen = 256;
+
+export type Progress = (transferred: number, total: number) => void;
+
+export declare interface EmitOptions {
+  headers?: Headers;
+
+  /** called with the intermediate progress reported by the handler */
+  onProgress?: (percent: number, ctx: Context) => void;
+
+  /** cancels the handler on the server when aborted */
+  signal?: AbortSignal;
+}
+
+interface transfer {
+  key: string;
+  total: number;
+  received: number;
+  data: Uint8Array;
+}
+
+export type Headers = Record<string, string>;
+
 interface payload {
   messageType: messageType;
   messageId: Uint8Array;
   /** long time operation ? */
   longtime: boolean;
+  /** only available since enzo-v1 */
+  headers?: Headers;
+  /** only available since enzo-v2 */
+  seq?: number;
   key?: string;
   data?: Uint8Array;
 }
@@ -48,10 +143,30 @@ export class Enzo {
 
   #ee: EventEmitter;
 
-  #socket: WebSocket;
+  /** listeners of the plugin channels, not removed by offAll */
+  #pluginEe: EventEmitter;
+
+  #socket: WebSocket | PollingSocket;
+
+  /** the websocket could not be opened, stay on long-polling */
+  #polling = false;
 
   #timers: Record<string, number>;
 
+  #transfers: Record<string, transfer>;
+
+  /** abort controllers of the in-flight incoming messages */
+  #aborts: Record<string, AbortController>;
+
+  /** recently received ids of reliable messages and their replies */
+  #seen: Record<string, Uint8Array | undefined>;
+
+  #seenPrev: Record<string, Uint8Array | undefined>;
+
+  #seenAt: number;
+
+  #seenCount: number;
+
   #heartbeatTimer: number;
 
   #forceClose: boolean;
@@ -82,8 +197,15 @@ export class Enzo {
     };
 
     this.#timers = {};
+    this.#transfers = {};
+    this.#aborts = {};
+    this.#seen = {};
+    this.#seenPrev = {};
+    this.#seenAt = Date.now();
+    this.#seenCount = 0;
 
     this.#ee = new EventEmitter();
+    this.#pluginEe = new EventEmitter();
     this.offAll();
 
     if (this.#opt.autoConnect) {
@@ -107,10 +229,41 @@ export class Enzo {
     this.#ee.on('ws_message', this.#wsmessage.bind(this));
   }
 
+  /** the negotiated protocol */
+  get protocol() {
+    return this.#socket?.protocol || 'enzo-v0';
+  }
+
+  /** the transport in use: 'websocket' or 'polling' */
+  get transport() {
+    return this.#socket instanceof PollingSocket ? 'polling' : 'websocket';
+  }
+
+  /** whether a websocket which fails to open is retried with long-polling */
+  get #canFallback() {
+    const list = this.#opt.transports || transports;
+    return !this.#polling && list.includes('polling');
+  }
+
+  get #withHeaders() {
+    return this.protocol !== 'enzo-v0';
+  }
+
+  get #withSeq() {
+    return this.protocol !== 'enzo-v0' && this.protocol !== 'enzo-v1';
+  }
+
+  /** sequence of the last frame received and sent (enzo-v2) */
+  #inSeq = 0;
+
+  #outSeq = 0;
+
   // make message frame
-  // * | base: (1+1+10=4=16) | messageType(1) | longtime(1) | messageId(10) | allLength(4) |
-  // ? | data: (4+x+4+x=y)   | keyLength(4)   | key(x)      | dataLength(4) | dataBody(x)  |
-  write(msgType: messageType, longtime: boolean, waitBack: boolean, callback: (e: Context | Error) => void, msgId?: Uint8Array, key?: string, data?: any) {
+  // * | base: (1+1+10+4=16) | messageType(1)  | longtime(1) | messageId(10) | allLength(4) |
+  // # | seq:  (8)           | sequence(8)     |                                               (since enzo-v2)
+  // ! | head: (4+x)         | headerLength(4) | headers(x)  |                                  (since enzo-v1)
+  // ? | data: (4+x+4+x=y)   | keyLength(4)    | key(x)      | dataLength(4) | dataBody(x)  |
+  write(msgType: messageType, longtime: boolean, waitBack: boolean, callback: (e: Context | Error) => void, msgId?: Uint8Array, key?: string, data?: any, headers?: Headers) {
     if (!msgId) msgId = crypto.getRandomValues(new Uint8Array(10));
     const msgid = bufid2string(msgId);
 
@@ -120,6 +273,10 @@ export class Enzo {
       return;
     }
 
+    if (msgType === messageType.BackMessage) {
+      delete this.#aborts[msgid];
+    }
+
     let keyBuf: Uint8Array | undefined;
     let dataBuf: Uint8Array | undefined;
 
@@ -142,9 +299,23 @@ export class Enzo {
       }
     }
 
+    let headBuf: Uint8Array | undefined;
+    if (this.#withHeaders && (keyBuf || (headers && Object.keys(headers).length))) {
+      headBuf = this.encodeHeaders(headers || {});
+      if (!keyBuf) keyBuf = new Uint8Array(0);
+    }
+
     let baseLength = 1 + 1 + 10 + 4;
     let dataLength = 0;
 
+    if (this.#withSeq) {
+      dataLength += 8;
+    }
+
+    if (headBuf) {
+      dataLength += 4 + headBuf.byteLength;
+    }
+
     if (keyBuf) {
       dataLength += 4 + keyBuf.byteLength;
 
@@ -174,17 +345,37 @@ export class Enzo {
     offset += msgId.byteLength;
 
     // allLength
-    let al = new Uint32Array([dataLength]);
+    let al = uint32le(dataLength);
     buf.set(al, offset);
     offset += al.byteLength;
 
+    // sequence, set on send
+    if (this.#withSeq) {
+      offset += 8;
+    }
+
+    // =============
+    // head
+    // =============
+
+    if (headBuf) {
+      // headlen
+      let hl = uint32le(headBuf.byteLength);
+      buf.set(hl, offset);
+      offset += hl.byteLength;
+
+      // headers
+      buf.set(headBuf, offset);
+      offset += headBuf.byteLength;
+    }
+
     // =============
     // data
     // =============
 
     if (keyBuf) {
       // keylen
-      let kl = new Uint32Array([keyBuf!.byteLength]);
+      let kl = uint32le(keyBuf!.byteLength);
       buf.set(kl, offset);
       offset += kl.byteLength;
 
@@ -193,7 +384,7 @@ export class Enzo {
       offset += keyBuf.byteLength;
 
       // datalen
-      let dl = new Uint32Array([dataBuf?.byteLength || 0]);
+      let dl = uint32le(dataBuf?.byteLength || 0);
       buf.set(dl, offset);
       offset += dl.byteLength;
 
@@ -206,9 +397,66 @@ export class Enzo {
     if (waitBack) {
       this.waitMessageReturn(msgid, longtime ? 0 : 6000, callback);
     }
+
+    // a retried message gets the same reply again
+    if (msgType === messageType.BackMessage) {
+      if (msgid in this.#seen) this.#seen[msgid] = buf;
+      else if (msgid in this.#seenPrev) this.#seenPrev[msgid] = buf;
+    }
+
+    this.#send(buf);
+  }
+
+  /** send a frame, stamped with the next sequence since enzo-v2 */
+  #send(buf: Uint8Array) {
+    if (this.#withSeq && buf.byteLength >= 24) {
+      new DataView(buf.buffer, buf.byteOffset, buf.byteLength).setBigUint64(16, BigInt(++this.#outSeq), true);
+    }
     this.#socket.send(buf);
   }
 
+  /**
+   * follows the sequence of the received frames, emits 'gap' when a frame does not follow
+   * the previous one, and returns false for a frame already received.
+   */
+  #checkSeq(seq: number): boolean {
+    // the first frame, or a server which started over
+    if (!this.#inSeq || seq === 1) {
+      this.#inSeq = seq;
+      return true;
+    }
+
+    // already received, e.g. replayed on resume
+    if (seq <= this.#inSeq) return false;
+
+    if (seq !== this.#inSeq + 1) {
+      this.#ee.emit('gap', this.#inSeq + 1, seq);
+    }
+    this.#inSeq = seq;
+    return true;
+  }
+
+  /**
+   * reports whether the reliable message was already received, and returns its reply if any.
+   * message ids are kept for up to a minute, in two generations swapped on expiry
+   * or once a generation holds maxSeen ids.
+   */
+  #received(msgid: string): [boolean, Uint8Array | undefined] {
+    if (Date.now() - this.#seenAt > 60e3 || this.#seenCount >= maxSeen) {
+      this.#seenPrev = this.#seen;
+      this.#seen = {};
+      this.#seenAt = Date.now();
+      this.#seenCount = 0;
+    }
+
+    if (msgid in this.#seen) return [true, this.#seen[msgid]];
+    if (msgid in this.#seenPrev) return [true, this.#seenPrev[msgid]];
+
+    this.#seen[msgid] = void 0;
+    this.#seenCount++;
+    return [false, void 0];
+  }
+
   waitMessageReturn(msgid: string, timeout: number, callback: (e: Context | Error) => void) {
     const self = this;
     let replied = false;
@@ -249,38 +497,166 @@ export class Enzo {
     });
   }
 
-  public emit(key: string, data: any, cb?: (res: Context | Error) => void): Promise<Context> {
+  public emit(key: string, data: any, cb?: (res: Context | Error) => void, opts?: EmitOptions): Promise<Context> {
+    return this.#emit(false, key, data, cb, opts);
+  }
+
+  public longtimeEmit(key: string, data: any, cb?: (res: Context | Error) => void, opts?: EmitOptions): Promise<Context> {
+    return this.#emit(true, key, data, cb, opts);
+  }
+
+  #emit(longtime: boolean, key: string, data: any, cb?: (res: Context | Error) => void, opts?: EmitOptions): Promise<Context> {
     const self = this;
+    const msgId = crypto.getRandomValues(new Uint8Array(10));
+    const msgid = bufid2string(msgId);
+    const progressEvent = `progress:${msgid}`;
+    const signal = opts?.signal;
+
+    if (signal?.aborted) {
+      return Promise.reject(new Error('aborted'));
+    }
+
+    if (opts?.onProgress) {
+      self.#ee.on(progressEvent, opts.onProgress);
+    }
+
+    const onAbort = () => {
+      self.write(messageType.CancelMessage, false, false, () => {}, msgId);
+      // settle the pending reply
+      self.#ee.emit(msgid, new Error('aborted'));
+    };
+    signal?.addEventListener('abort', onAbort, { once: true });
+
     return new Promise((resolve, reject) => {
-      self.write(messageType.PostMessage, false, true, (res: Context | Error) => {
+      self.write(messageType.PostMessage, longtime, true, (res: Context | Error) => {
+        signal?.removeEventListener('abort', onAbort);
+        self.#ee.removeAllListeners(progressEvent);
         setTimeout(() => { cb && isFunc(cb) && cb(res); }, 0);
         if (res instanceof Error) {
           reject(res);
         } else {
           resolve(res);
         }
-      }, void 0, key, data);
+      }, msgId, key, data, opts?.headers);
     });
   }
 
-  public longtimeEmit(key: string, data: any, cb?: (res: Context | Error) => void): Promise<Context> {
+  /**
+   * emit a message whose handler replies with a stream, e.g:
+   * for await (const part of enzo.stream('logs', { tail: 100 })) { ... }
+   */
+  public stream(key: string, data: any, headers?: Headers): AsyncIterableIterator<Context> {
     const self = this;
-    return new Promise((resolve, reject) => {
-      self.write(messageType.PostMessage, true, true, (res: Context | Error) => {
-        setTimeout(() => { cb && isFunc(cb) && cb(res); }, 0);
-        if (res instanceof Error) {
-          reject(res);
+    const msgId = crypto.getRandomValues(new Uint8Array(10));
+    const msgid = bufid2string(msgId);
+
+    type waiter = { resolve: (r: IteratorResult<Context>) => void; reject: (e: Error) => void };
+    const queue: Context[] = [];
+    const waiters: waiter[] = [];
+    let ended = false;
+    let error: Error | undefined;
+
+    const settle = () => {
+      while (waiters.length) {
+        const w = waiters.shift()!;
+        if (queue.length) {
+          w.resolve({ value: queue.shift()!, done: false });
+        } else if (error) {
+          w.reject(error);
+        } else if (ended) {
+          w.resolve({ value: undefined, done: true });
         } else {
-          resolve(res);
+          waiters.unshift(w);
+          break;
         }
-      }, void 0, key, data);
+      }
+    };
+
+    const cleanup = () => {
+      self.#ee.removeAllListeners(`stream:${msgid}`);
+      self.#ee.removeAllListeners(msgid);
+    };
+
+    self.#ee.on(`stream:${msgid}`, (status: streamStatus, ctx: Context) => {
+      if (status === streamStatus.data) {
+        queue.push(ctx);
+      } else {
+        if (status === streamStatus.error) {
+          error = new Error(self.buffer2string(ctx.data || new Uint8Array(0)) || 'stream failed');
+        }
+        ended = true;
+        cleanup();
+      }
+      settle();
+    });
+
+    // the handler replied with a single write
+    self.waitMessageReturn(msgid, 0, (res: Context | Error) => {
+      if (res instanceof Error) {
+        error = res;
+      } else {
+        queue.push(res);
+      }
+      ended = true;
+      cleanup();
+      settle();
     });
+
+    self.write(messageType.PostMessage, true, false, (e: Context | Error) => {
+      if (e instanceof Error) {
+        error = e;
+        ended = true;
+        cleanup();
+        settle();
+      }
+    }, msgId, key, data, headers);
+
+    return {
+      next() {
+        return new Promise<IteratorResult<Context>>((resolve, reject) => {
+          waiters.push({ resolve, reject });
+          settle();
+        });
+      },
+      return() {
+        // the consumer stopped early, the handler does not need to go on
+        if (!ended) self.write(messageType.CancelMessage, false, false, () => {}, msgId);
+        ended = true;
+        cleanup();
+        settle();
+        return Promise.resolve({ value: undefined, done: true });
+      },
+      [Symbol.asyncIterator]() {
+        return this;
+      },
+    };
   }
 
   #connected: boolean;
 
   #connectTimer: number;
 
+  #connid: string;
+
+  #resumeToken: string;
+
+  /** the connection id given by the server, kept across resumed reconnects */
+  get connid() {
+    return this.#connid;
+  }
+
+  #address(): string {
+    if (!this.#resumeToken) return this.#opt.address;
+    try {
+      const url = new URL(this.#opt.address);
+      url.searchParams.set('enzo_resume', this.#resumeToken);
+      url.searchParams.set('enzo_seq', String(this.#inSeq));
+      return url.toString();
+    } catch (err) {
+      return this.#opt.address;
+    }
+  }
+
   get connected() {
     return this.#connected;
   }
@@ -301,12 +677,43 @@ export class Enzo {
           self.#doReconnect();
         }, 2000);
 
-        if (self.#socket) self.#socket.close(1000);
-        self.#socket = new WebSocket(self.#opt.address, ['enzo-v0']);
+        if (self.#socket) self.#socket.close(CloseReconnect);
+        const list = self.#opt.transports || transports;
+        if (self.#polling || !list.includes('websocket')) {
+          self.#socket = new PollingSocket(self.#address(), self.#opt.protocols || protocols);
+        } else {
+          self.#socket = new WebSocket(self.#address(), self.#opt.protocols);
+        }
 
         self.#socket.binaryType = 'arraybuffer';
 
+        self.#socket.onclose = function (e: CloseEvent) {
+          // the websocket can not be opened, e.g. broken by a proxy
+          if (e.code !== CloseProtocolError && self.#socket instanceof WebSocket && self.#canFallback) {
+            self.#polling = true;
+            if (self.#connectTimer) clearTimeout(self.#connectTimer);
+            self.connect().then(resolve, reject);
+            return;
+          }
+
+          // the server may be down rather than the websocket broken, try it again next time
+          if (e.code !== CloseProtocolError && self.#socket instanceof PollingSocket) {
+            self.#polling = false;
+            return;
+          }
+
+          if (e.code !== CloseProtocolError) return;
+
+          // the server does not speak any offered protocol, retrying will not help
+          self.#forceClose = true;
+          if (self.#connectTimer) clearTimeout(self.#connectTimer);
+          reject(new Error(e.reason || 'unsupported protocol'));
+        };
+
         self.#socket.onerror = function (e: Event) {
+          // the close which follows falls back to long-polling
+          if (self.#socket instanceof WebSocket && self.#canFallback) return;
+
           reject(e);
 
           self.#ee.emit('ws_error', e);
@@ -357,8 +764,7 @@ export class Enzo {
   public reconnect() {
     if (this.#connected) return;
 
-    // Close code https://www.rfc-editor.org/rfc/rfc6455.html#section-7.1.5
-    this.#socket.close(1000);
+    this.#socket.close(CloseReconnect);
     return this.connect();
   }
 
@@ -426,7 +832,20 @@ export class Enzo {
     let _allLenView = new DataView(_allLen, 0);
     let allLength = _allLenView.getUint32(0, true);
 
+    // pong is the echo of a ping and has no sequence
+    if (this.#withSeq && res.messageType !== messageType.PongMessage && e.data.byteLength >= 24) {
+      const seq = Number(new DataView(e.data, offset, 8).getBigUint64(0, true));
+      offset += 8;
+      res.seq = seq;
+      if (!this.#checkSeq(seq)) return;
+    }
+
     if (!allLength || !(allLength - offset)) {
+      if (res.messageType === messageType.CancelMessage) {
+        this.#aborts[msgid]?.abort();
+        delete this.#aborts[msgid];
+        return;
+      }
       if (res.messageType === messageType.PongMessage) {
         this.#ee.emit(msgid, new Context(this, res));
         return;
@@ -441,13 +860,23 @@ export class Enzo {
       return;
     }
 
-    if ((e.data.byteLength - 16) !== allLength - offset) {
+    if (e.data.byteLength !== allLength) {
       // TODO
       // mismatched body length
       console.error('mismatched body length');
       return;
     }
 
+    if (this.#withHeaders) {
+      // headlen
+      let _headlen = e.data.slice(offset, (offset += 4));
+      let _headLenView = new DataView(_headlen, 0);
+      let headLength = _headLenView.getUint32(0, true);
+
+      // headers
+      res.headers = this.decodeHeaders(new Uint8Array(e.data.slice(offset, (offset += headLength))));
+    }
+
     // keylen
     let _keylen = e.data.slice(offset, (offset += 4));
     let _keyLenView = new DataView(_keylen, 0);
@@ -471,13 +900,195 @@ export class Enzo {
       return;
     }
 
-    this.#ee.emit(res.key, new Context(this, res));
+    if (res.messageType === messageType.ResumeMessage) {
+      const resumed = this.#connid === res.key;
+      this.#connid = res.key || '';
+      this.#resumeToken = this.buffer2string(res.data || new Uint8Array(0));
+      if (resumed) this.#ee.emit('resume');
+      return;
+    }
+
+    if (res.messageType === messageType.ChunkMessage) {
+      this.#receiveChunk(msgid, res);
+      return;
+    }
+
+    if (res.messageType === messageType.ChunkAckMessage) {
+      this.#ee.emit(`ack:${msgid}`, new Context(this, res));
+      return;
+    }
+
+    if (res.messageType === messageType.ProgressMessage) {
+      const percent = res.data?.at(0) || 0;
+      this.#ee.emit(`progress:${msgid}`, percent, new Context(this, { ...res, data: res.data?.slice(1) }));
+      return;
+    }
+
+    if (res.messageType === messageType.StreamMessage) {
+      const status = res.data?.at(0);
+      this.#ee.emit(`stream:${msgid}`, status, new Context(this, { ...res, data: res.data?.slice(1) }));
+      return;
+    }
+
+    // a retry of a reliable message already received
+    if (res.headers?.[reliableHeader]) {
+      const [dup, reply] = this.#received(msgid);
+      if (dup) {
+        if (reply) this.#send(reply);
+        return;
+      }
+    }
+
+    // only the channel of the plugin gets its messages
+    if (res.messageType === messageType.PluginMessage) {
+      this.#pluginEe.emit(res.key, new Context(this, res, this.#track(msgid)));
+      return;
+    }
+
+    this.#ee.emit(res.key, new Context(this, res, this.#track(msgid)));
+  }
+
+  #track(msgid: string): AbortSignal {
+    const controller = new AbortController();
+    this.#aborts[msgid] = controller;
+    return controller.signal;
+  }
+
+  // chunk data: | total(8) | offset(8) | chunk(x) |
+  #receiveChunk(msgid: string, res: payload) {
+    const raw = res.data || new Uint8Array(0);
+    const ack = (received: number) => {
+      const buf = new Uint8Array(9);
+      const view = new DataView(buf.buffer);
+      view.setUint8(0, 0x01);
+      view.setBigUint64(1, BigInt(received), true);
+      this.write(messageType.ChunkAckMessage, true, false, () => {}, res.messageId, res.key, buf);
+    };
+
+    if (raw.byteLength < 16) {
+      console.error('mismatched chunk length');
+      return;
+    }
+    const view = new DataView(raw.buffer, raw.byteOffset, raw.byteLength);
+    const total = Number(view.getBigUint64(0, true));
+    const offset = Number(view.getBigUint64(8, true));
+    const chunk = raw.slice(16);
+
+    if (!(msgid in this.#transfers)) {
+      this.#transfers[msgid] = {
+        key: res.key || '',
+        total,
+        received: 0,
+        data: new Uint8Array(total),
+      };
+    }
+    const t = this.#transfers[msgid];
+
+    // a chunk already received, or a gap: tell the sender where to continue
+    if (offset !== t.received) {
+      ack(t.received);
+      return;
+    }
+
+    t.data.set(chunk, offset);
+    t.received += chunk.byteLength;
+
+    this.#ee.emit('transfer', { key: t.key, received: t.received, total: t.total });
+    ack(t.received);
+
+    if (t.received >= t.total) {
+      delete this.#transfers[msgid];
+      this.#ee.emit(t.key, new Context(this, {
+        ...res,
+        messageType: messageType.PostMessage,
+        longtime: true,
+        data: t.data,
+      }, this.#track(msgid)));
+    }
+  }
+
+  /**
+   * send a large payload as a sequence of chunks, every chunk waits for its acknowledgement.
+   * unacknowledged chunks are sent again, so a transfer survives a reconnect.
+   */
+  public transfer(key: string, data: Uint8Array, onProgress?: Progress, cb?: (res: Context | Error) => void, headers?: Headers): Promise<Context> {
+    const self = this;
+    const msgId = crypto.getRandomValues(new Uint8Array(10));
+    const msgid = bufid2string(msgId);
+    const total = data.byteLength;
+
+    const waitAck = (offset: number): Promise<number> => new Promise((resolve, reject) => {
+      const event = `ack:${msgid}`;
+      const timer = window.setTimeout(() => {
+        self.#ee.removeAllListeners(event);
+        reject(new Error('timeout'));
+      }, 6000);
+
+      self.#ee.once(event, (ctx: Context) => {
+        clearTimeout(timer);
+
+        // | status(1) | received(8) | message(x) |
+        const raw = ctx.data || new Uint8Array(0);
+        if (raw.byteLength < 9) return reject(new Error('mismatched ack length'));
+        const view = new DataView(raw.buffer, raw.byteOffset, raw.byteLength);
+        if (view.getUint8(0) !== 0x01) return reject(new Error(self.buffer2string(raw.slice(9)) || 'transfer failed'));
+
+        resolve(Number(view.getBigUint64(1, true)));
+      });
+
+      const chunk = data.slice(offset, offset + chunkSize);
+      const buf = new Uint8Array(16 + chunk.byteLength);
+      const head = new DataView(buf.buffer);
+      head.setBigUint64(0, BigInt(total), true);
+      head.setBigUint64(8, BigInt(offset), true);
+      buf.set(chunk, 16);
+
+      self.write(messageType.ChunkMessage, true, false, () => {}, msgId, key, buf, headers);
+    });
+
+    return new Promise((resolve, reject) => {
+      const done = (res: Context | Error) => {
+        setTimeout(() => { cb && isFunc(cb) && cb(res); }, 0);
+        if (res instanceof Error) {
+          reject(res);
+        } else {
+          resolve(res);
+        }
+      };
+
+      // the reply, after the last chunk
+      self.waitMessageReturn(msgid, 0, done);
+
+      (async () => {
+        let offset = 0;
+        let retries = 0;
+        do {
+          try {
+            offset = await waitAck(offset);
+            retries = 0;
+          } catch (err) {
+            if ((err as Error).message !== 'timeout' || ++retries > 5) throw err;
+            continue;
+          }
+          onProgress && onProgress(offset, total);
+        } while (offset < total);
+      })().catch((err: Error) => {
+        self.#ee.removeAllListeners(msgid);
+        done(err);
+      });
+    });
   }
 
   #wserror(_e: Event) {
   }
 
-  #wsclose(_e: CloseEvent) {
+  #wsclose(e: CloseEvent) {
+    const reconnect = (this.#opt.shouldReconnect || shouldReconnect)(e.code, e.reason);
+    if (!reconnect) {
+      this.#forceClose = true;
+      this.#ee.emit('kicked', e.code, e.reason);
+    }
+
     this.#setConnected(false, true);
     this.#clearHeartbeatTimer();
 
@@ -486,6 +1097,11 @@ export class Enzo {
       delete this.#timers[msgid];
     }
 
+    for (const msgid in this.#aborts) {
+      this.#aborts[msgid].abort();
+      delete this.#aborts[msgid];
+    }
+
     // set reconnect
     this.#doReconnect();
   }
@@ -543,11 +1159,29 @@ export class Enzo {
     for (let plugin of args) {
       if (plugin.install) {
         console.log('register plugin:', plugin.pluginName);
-        plugin.install(this, messageType.PluginMessage);
+        plugin.install(this, this.#channel(plugin.pluginName));
       }
     }
   }
 
+  #channel(pluginName: string): PluginChannel {
+    const self = this;
+    const key = (action: string) => `${pluginName}|${action}`;
+
+    return {
+      messageType: messageType.PluginMessage,
+      on(action: string, handle: (ctx: Context) => void) {
+        self.#pluginEe.on(key(action), handle);
+      },
+      off(action: string, handle?: (ctx: Context) => void) {
+        self.#pluginEe.removeListener(key(action), handle);
+      },
+      emit(action: string, data: Uint8Array, callback: (e: Context | Error) => void) {
+        self.write(messageType.PluginMessage, false, true, callback, void 0, key(action), data);
+      },
+    };
+  }
+
   string2buffer(str: string): Uint8Array {
     return new TextEncoder().encode(str);
   }
@@ -555,6 +1189,44 @@ export class Enzo {
   buffer2string(buf: Uint8Array): string {
     return new TextDecoder('utf-8').decode(buf);
   }
+
+  // every header is encoded as | nameLength(4) | name(x) | valueLength(4) | value(x) |
+  encodeHeaders(headers: Headers): Uint8Array {
+    const parts: Uint8Array[] = [];
+    for (const name in headers) {
+      const n = this.string2buffer(name);
+      const v = this.string2buffer(headers[name]);
+      parts.push(uint32le(n.byteLength), n, uint32le(v.byteLength), v);
+    }
+
+    let len = 0;
+    for (const p of parts) len += p.byteLength;
+
+    const buf = new Uint8Array(len);
+    let offset = 0;
+    for (const p of parts) {
+      buf.set(p, offset);
+      offset += p.byteLength;
+    }
+    return buf;
+  }
+
+  decodeHeaders(raw: Uint8Array): Headers {
+    const headers: Headers = {};
+    const view = new DataView(raw.buffer, raw.byteOffset, raw.byteLength);
+
+    let offset = 0;
+    while (offset + 4 <= raw.byteLength) {
+      const nl = view.getUint32(offset, true);
+      offset += 4;
+      const name = this.buffer2string(raw.slice(offset, (offset += nl)));
+
+      const vl = view.getUint32(offset, true);
+      offset += 4;
+      headers[name] = this.buffer2string(raw.slice(offset, (offset += vl)));
+    }
+    return headers;
+  }
 }
 
 export class Context {
@@ -566,10 +1238,15 @@ export class Context {
 
   #replyTimer: number;
 
-  constructor(enzo: Enzo, payload: payload) {
+  #headers: Headers;
+
+  #signal: AbortSignal;
+
+  constructor(enzo: Enzo, payload: payload, signal?: AbortSignal) {
     this.#enzo = enzo;
     this.#payload = payload;
     this.#replied = false;
+    this.#signal = signal || new AbortController().signal;
 
     if ((this.#payload.messageType === messageType.PostMessage || this.#payload.messageType === messageType.PluginMessage) && !payload.longtime) {
       this.#replyTimer = window.setTimeout(() => {
@@ -592,6 +1269,31 @@ export class Context {
     return this.#payload.data;
   }
 
+  /** sequence number of the received frame, 0 before enzo-v2 */
+  get seq(): number {
+    return this.#payload.seq || 0;
+  }
+
+  /** aborted when the sender cancels the message or the connection is closed */
+  get signal(): AbortSignal {
+    return this.#signal;
+  }
+
+  /** headers of the received frame */
+  get headers(): Headers {
+    return this.#payload.headers || {};
+  }
+
+  public header(name: string): string | undefined {
+    return this.#payload.headers?.[name];
+  }
+
+  /** set a header of the reply */
+  public setHeader(name: string, value: string) {
+    if (!this.#headers) this.#headers = {};
+    this.#headers[name] = value;
+  }
+
   get emit() {
     return this.#enzo.emit.bind(this);
   }
@@ -602,7 +1304,7 @@ export class Context {
 
   public write(data: any) {
     this.#replied = true;
-    this.#enzo.write(messageType.BackMessage, false, false, () => { }, this.#payload.messageId, this.#payload.key, data);
+    this.#enzo.write(messageType.BackMessage, false, false, () => { }, this.#payload.messageId, this.#payload.key, data, this.#headers);
   }
 }
 
@@ -637,6 +1339,8 @@ const bufid2string = (buf: Uint8Array) => buf.reduce((id, byte) => {
   return id;
 }, '');
 
+const uint32le = (n: number) => new Uint8Array(new Uint32Array([n]).buffer);
+
 const isFunc = (like: any): boolean => typeof like === 'function';
 
 export default { Enzo, Context };
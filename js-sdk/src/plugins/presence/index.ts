@@ -0,0 +1,102 @@
+import type { Enzo, Context, Plugin, PluginChannel } from '../../index';
+
+// status of a presence|change message: | status(1) | userID(x) |
+enum status {
+  online = 0x01,
+  offline = 0x02,
+}
+
+export type ChangeHandle = (online: boolean, userID: string) => void;
+
+export class Presence implements Plugin {
+  #enzo: Enzo;
+
+  #channel: PluginChannel;
+
+  #userID = '';
+
+  #watchers: Record<string, ChangeHandle[]> = {};
+
+  get pluginName() {
+    return 'presence';
+  }
+
+  install(enzo: Enzo, channel: PluginChannel) {
+    this.#enzo = enzo;
+
+    this.#channel = channel;
+
+    channel.on('change', this.#onChange.bind(this));
+
+    // a new connection is neither identified nor watching
+    enzo.on('connect', () => {
+      if (this.#userID) this.#send('identify', this.#userID).catch(() => {});
+      for (const userID in this.#watchers) {
+        this.#send('watch', userID).catch(() => {});
+      }
+    });
+  }
+
+  #send(action: string, userID: string): Promise<Uint8Array> {
+    return new Promise((resolve, reject) => {
+      this.#channel.emit(action, this.#enzo.string2buffer(userID), (e: Context | Error) => {
+        if (e instanceof Error) {
+          reject(e);
+        } else {
+          resolve(e.data || new Uint8Array(0));
+        }
+      });
+    });
+  }
+
+  #onChange(ctx: Context) {
+    const raw = ctx.data || new Uint8Array(0);
+    ctx.write(new Uint8Array(0));
+
+    if (!raw.byteLength) return;
+    const online = raw.at(0) === status.online;
+    const userID = this.#enzo.buffer2string(raw.slice(1));
+
+    for (const handle of this.#watchers[userID] || []) {
+      handle(online, userID);
+    }
+  }
+
+  /** binds the connection to a user when the server authorizes it, the server may identify it on its own instead */
+  async identify(userID: string): Promise<void> {
+    const b = await this.#send('identify', userID);
+    if (b.at(0) !== status.online) {
+      throw new Error('identify failed');
+    }
+    this.#userID = userID;
+  }
+
+  /** calls handle whenever the user goes online or offline, resolves with the current status */
+  async watch(userID: string, handle: ChangeHandle): Promise<boolean> {
+    if (!(userID in this.#watchers)) this.#watchers[userID] = [];
+    this.#watchers[userID].push(handle);
+
+    const b = await this.#send('watch', userID);
+    return b.at(0) === status.online;
+  }
+
+  async unwatch(userID: string, handle?: ChangeHandle): Promise<void> {
+    if (!(userID in this.#watchers)) return;
+
+    if (handle) {
+      this.#watchers[userID] = this.#watchers[userID].filter((h) => h !== handle);
+      if (this.#watchers[userID].length) return;
+    }
+    delete this.#watchers[userID];
+
+    await this.#send('unwatch', userID);
+  }
+}
+
+export default { Presence };
+
+if (window) {
+  Object.defineProperty(window, 'EnzoPresence', {
+    value: Presence,
+  });
+}
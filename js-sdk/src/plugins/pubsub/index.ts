@@ -0,0 +1,137 @@
+import type { Enzo, Context, Plugin, PluginChannel } from '../../index';
+
+export type MessageHandle = (data: Uint8Array, topic: string) => void;
+
+export class PubSub implements Plugin {
+  #enzo: Enzo;
+
+  #channel: PluginChannel;
+
+  #handlers: Record<string, MessageHandle[]> = {};
+
+  get pluginName() {
+    return 'pubsub';
+  }
+
+  install(enzo: Enzo, channel: PluginChannel) {
+    this.#enzo = enzo;
+
+    this.#channel = channel;
+
+    channel.on('message', this.#onMessage.bind(this));
+
+    // a new connection has no subscription
+    enzo.on('connect', () => {
+      for (const topic in this.#handlers) {
+        this.#send('subscribe', this.#enzo.string2buffer(topic)).catch(() => {});
+      }
+    });
+  }
+
+  // 0x01 == normal
+  // 0x02 == error
+  parseResponse(raw: Uint8Array): Uint8Array | Error | undefined {
+    let offset = 0;
+
+    const stats = raw.at(offset);
+    offset += 1;
+
+    const _bodyLen = new Uint8Array(raw.slice(offset, offset += 4));
+    const _bodyView = new DataView(_bodyLen.buffer, 0);
+    const bodyLen = _bodyView.getUint32(0, true);
+
+    const body = raw.slice(offset, (offset += bodyLen));
+
+    if (stats === 0x01) {
+      return body;
+    }
+    if (stats === 0x02) {
+      const msg = this.#enzo.buffer2string(body);
+      return new Error(msg);
+    }
+    return void 0;
+  }
+
+  #send(op: string, buf: Uint8Array): Promise<void> {
+    return new Promise((resolve, reject) => {
+      this.#channel.emit(op, buf, (e: Context | Error) => {
+        if (e instanceof Error) {
+          reject(e);
+        } else if (e.data) {
+          const b = this.parseResponse(e.data);
+          if (b instanceof Error) {
+            reject(b);
+          } else {
+            resolve();
+          }
+        } else {
+          reject(new Error('empty'));
+        }
+      });
+    });
+  }
+
+  // topiclen + topic + data
+  #onMessage(ctx: Context) {
+    const raw = ctx.data || new Uint8Array(0);
+    ctx.write(new Uint8Array(0));
+
+    if (raw.byteLength < 4) return;
+    const view = new DataView(raw.buffer, raw.byteOffset, raw.byteLength);
+    const topicLen = view.getUint32(0, true);
+    const topic = this.#enzo.buffer2string(raw.slice(4, 4 + topicLen));
+    const data = raw.slice(4 + topicLen);
+
+    for (const handle of this.#handlers[topic] || []) {
+      handle(data, topic);
+    }
+  }
+
+  async subscribe(topic: string, handle: MessageHandle): Promise<void> {
+    const first = !(topic in this.#handlers);
+    if (first) this.#handlers[topic] = [];
+    this.#handlers[topic].push(handle);
+
+    if (!first) return;
+
+    try {
+      await this.#send('subscribe', this.#enzo.string2buffer(topic));
+    } catch (err) {
+      delete this.#handlers[topic];
+      throw err;
+    }
+  }
+
+  async unsubscribe(topic: string, handle?: MessageHandle): Promise<void> {
+    if (!(topic in this.#handlers)) return;
+
+    if (handle) {
+      this.#handlers[topic] = this.#handlers[topic].filter((h) => h !== handle);
+      if (this.#handlers[topic].length) return;
+    }
+    delete this.#handlers[topic];
+
+    await this.#send('unsubscribe', this.#enzo.string2buffer(topic));
+  }
+
+  publish(topic: string, data: Uint8Array | string): Promise<void> {
+    const topicBuf = this.#enzo.string2buffer(topic);
+    const dataBuf = typeof data === 'string' ? this.#enzo.string2buffer(data) : data;
+
+    // topiclen + topic + data
+    const buf = new Uint8Array(4 + topicBuf.byteLength + dataBuf.byteLength);
+    new DataView(buf.buffer).setUint32(0, topicBuf.byteLength, true);
+    buf.set(topicBuf, 4);
+    buf.set(dataBuf, 4 + topicBuf.byteLength);
+
+    return this.#send('publish', buf);
+  }
+}
+
+export default { PubSub };
+
+if (window) {
+  Object.defineProperty(window, 'EnzoPubSub', {
+    value: PubSub,
+  });
+}
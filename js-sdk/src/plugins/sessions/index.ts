@@ -1,4 +1,4 @@
-import type { Enzo, Context, Plugin } from '../../index';
+import type { Enzo, Context, Plugin, PluginChannel } from '../../index';
 
 // export declare interface SessionsOptions {
 //   some?: string;
@@ -17,7 +17,7 @@ export class Sessions implements Plugin {
 
   #enzo: Enzo;
 
-  #messageType: number;
+  #channel: PluginChannel;
 
   // constructor(opt?: SessionsOptions) {
   //   this.#opt = {
@@ -30,10 +30,10 @@ export class Sessions implements Plugin {
     return 'sessions';
   }
 
-  install(enzo: Enzo, messageType: number) {
+  install(enzo: Enzo, channel: PluginChannel) {
     this.#enzo = enzo;
 
-    this.#messageType = messageType;
+    this.#channel = channel;
   }
 
   // 0x01 == normal
@@ -83,7 +83,7 @@ export class Sessions implements Plugin {
       buf.set(keyBuf, offset);
       offset += keyBuf.byteLength;
 
-      this.#enzo.write(this.#messageType, false, true, (e: Context | Error) => {
+      this.#channel.emit('get', buf, (e: Context | Error) => {
         if (e instanceof Error) {
           reject(e);
         } else if (e.data) {
@@ -97,7 +97,7 @@ export class Sessions implements Plugin {
         } else {
           reject(new Error('empty'));
         }
-      }, void 0, this.pluginName + '|get', buf);
+      });
     });
   }
 
@@ -214,7 +214,7 @@ export class Sessions implements Plugin {
       // data
       buf.set(data, offset);
 
-      this.#enzo.write(this.#messageType, false, true, (e: Context | Error) => {
+      this.#channel.emit('set', buf, (e: Context | Error) => {
         if (e instanceof Error) {
           reject(e);
         } else if (e.data) {
@@ -228,7 +228,7 @@ export class Sessions implements Plugin {
         } else {
           reject(new Error('empty'));
         }
-      }, void 0, this.pluginName + '|set', buf);
+      });
     });
   }
 
@@ -300,7 +300,7 @@ export class Sessions implements Plugin {
       buf.set(keyBuf, offset);
       offset += keyBuf.byteLength;
 
-      this.#enzo.write(this.#messageType, false, true, (e: Context | Error) => {
+      this.#channel.emit('ttl', buf, (e: Context | Error) => {
         if (e instanceof Error) {
           reject(e);
         } else if (e.data) {
@@ -314,7 +314,7 @@ export class Sessions implements Plugin {
         } else {
           reject(new Error('empty'));
         }
-      }, void 0, this.pluginName + '|ttl', buf);
+      });
     });
   }
 
@@ -324,7 +324,7 @@ export class Sessions implements Plugin {
 
   sizes(cb?: (a1: number) => void): Promise<number> {
     return new Promise((resolve, reject) => {
-      this.#enzo.write(this.#messageType, false, true, (e: Context | Error) => {
+      this.#channel.emit('sizes', new Uint8Array(0), (e: Context | Error) => {
         if (e instanceof Error) {
           reject(e);
         } else if (e.data) {
@@ -348,20 +348,20 @@ export class Sessions implements Plugin {
         } else {
           reject(new Error('empty'));
         }
-      }, void 0, this.pluginName + '|sizes');
+      });
     });
   }
 
   clean(cb?: () => void): Promise<void> {
     return new Promise((resolve, reject) => {
-      this.#enzo.write(this.#messageType, false, true, (e: Context | Error) => {
+      this.#channel.emit('clean', new Uint8Array(0), (e: Context | Error) => {
         if (e instanceof Error) {
           reject(e);
         } else {
           cb && cb();
           resolve();
         }
-      }, void 0, this.pluginName + '|clean');
+      });
     });
   }
 }
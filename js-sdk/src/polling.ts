@@ -0,0 +1,206 @@
+// A WebSocket look-alike over HTTP long-polling, for the clients behind proxies which break
+// websockets. The server must call EnablePolling; the frames of a body are | length(4) | frame(x) |.
+
+const CloseNormal = 1000;
+const CloseAbnormal = 1006;
+
+/** failed polls in a row before the socket is given up, the server sends the lost frames again */
+const maxPollRetries = 3;
+
+export class PollingSocket {
+  static readonly CONNECTING = 0;
+
+  static readonly OPEN = 1;
+
+  static readonly CLOSING = 2;
+
+  static readonly CLOSED = 3;
+
+  binaryType = 'arraybuffer';
+
+  readyState = PollingSocket.CONNECTING;
+
+  protocol = '';
+
+  onopen: ((e: Event) => void) | null = null;
+
+  onclose: ((e: CloseEvent) => void) | null = null;
+
+  onerror: ((e: Event) => void) | null = null;
+
+  onmessage: ((e: MessageEvent) => void) | null = null;
+
+  #url: URL;
+
+  #sid = '';
+
+  /** frames waiting to be posted */
+  #queue: Uint8Array[] = [];
+
+  #flushing = false;
+
+  /** frames received so far, acknowledged by the next poll */
+  #received = 0;
+
+  constructor(address: string, protocols: string[]) {
+    this.#url = new URL(address);
+    this.#url.protocol = this.#url.protocol === 'wss:' ? 'https:' : 'http:';
+    this.#url.searchParams.set('enzo_transport', 'poll');
+
+    this.#open(protocols);
+  }
+
+  #session(params: Record<string, string> = {}): string {
+    const url = new URL(this.#url.toString());
+    url.searchParams.set('enzo_sid', this.#sid);
+    for (const k in params) url.searchParams.set(k, params[k]);
+    return url.toString();
+  }
+
+  async #open(protocols: string[]) {
+    const url = new URL(this.#url.toString());
+    url.searchParams.set('enzo_protocol', protocols.join(','));
+
+    let res: Response;
+    try {
+      res = await fetch(url.toString(), { method: 'POST' });
+    } catch (err) {
+      this.#fail();
+      return;
+    }
+    if (res.status === 410) {
+      await this.#closedBy(res);
+      return;
+    }
+    if (!res.ok) {
+      this.#fail();
+      return;
+    }
+
+    const { sid, protocol } = await res.json();
+    this.#sid = sid;
+    this.protocol = protocol;
+    this.readyState = PollingSocket.OPEN;
+    this.onopen?.(new Event('open'));
+
+    this.#poll();
+    this.#flush();
+  }
+
+  async #poll() {
+    let retries = 0;
+    while (this.readyState === PollingSocket.OPEN) {
+      let res: Response;
+      let buf: Uint8Array;
+      try {
+        res = await fetch(this.#session({ enzo_ack: String(this.#received) }), { method: 'GET' });
+        if (res.status === 410) {
+          await this.#closedBy(res);
+          return;
+        }
+        if (!res.ok) throw new Error(`poll failed: ${res.status}`);
+        buf = new Uint8Array(await res.arrayBuffer());
+      } catch (err) {
+        if (++retries > maxPollRetries) {
+          this.#fail();
+          return;
+        }
+        await new Promise((resolve) => setTimeout(resolve, 1000 * retries));
+        continue;
+      }
+      retries = 0;
+      if (this.readyState !== PollingSocket.OPEN) return;
+
+      if (res.status === 204) continue;
+
+      const view = new DataView(buf.buffer);
+      let offset = 0;
+      while (offset + 4 <= buf.byteLength) {
+        const len = view.getUint32(offset, true);
+        // a cut frame is sent again by the next poll
+        if (offset + 4 + len > buf.byteLength) break;
+        offset += 4;
+        const frame = buf.slice(offset, (offset += len));
+        this.#received++;
+        this.onmessage?.(new MessageEvent('message', { data: frame.buffer }));
+      }
+    }
+  }
+
+  send(data: Uint8Array) {
+    if (this.readyState > PollingSocket.OPEN) return;
+
+    this.#queue.push(data);
+    this.#flush();
+  }
+
+  async #flush() {
+    if (this.#flushing || this.readyState !== PollingSocket.OPEN) return;
+    this.#flushing = true;
+
+    while (this.#queue.length && this.readyState === PollingSocket.OPEN) {
+      const frames = this.#queue.splice(0);
+
+      let size = 0;
+      for (const f of frames) size += 4 + f.byteLength;
+      const body = new Uint8Array(size);
+      const view = new DataView(body.buffer);
+      let offset = 0;
+      for (const f of frames) {
+        view.setUint32(offset, f.byteLength, true);
+        offset += 4;
+        body.set(f, offset);
+        offset += f.byteLength;
+      }
+
+      try {
+        const res = await fetch(this.#session(), { method: 'POST', body });
+        if (!res.ok) {
+          this.#fail();
+          break;
+        }
+      } catch (err) {
+        this.#fail();
+        break;
+      }
+    }
+
+    this.#flushing = false;
+  }
+
+  close(code = CloseNormal, reason = '') {
+    if (this.readyState >= PollingSocket.CLOSING) return;
+
+    if (this.#sid) {
+      fetch(this.#session({ enzo_code: String(code) }), { method: 'DELETE' }).catch(() => {});
+    }
+    this.#closed(code, reason);
+  }
+
+  async #closedBy(res: Response) {
+    let code = CloseAbnormal;
+    let reason = '';
+    try {
+      const body = await res.json();
+      code = body.code;
+      reason = body.reason;
+    } catch (err) {
+      // keep abnormal
+    }
+    this.#closed(code, reason);
+  }
+
+  #fail() {
+    if (this.readyState === PollingSocket.CLOSED) return;
+
+    this.onerror?.(new Event('error'));
+    this.#closed(CloseAbnormal, '');
+  }
+
+  #closed(code: number, reason: string) {
+    if (this.readyState === PollingSocket.CLOSED) return;
+
+    this.readyState = PollingSocket.CLOSED;
+    this.onclose?.(new CloseEvent('close', { code, reason, wasClean: code !== CloseAbnormal }));
+  }
+}
@@ -2,7 +2,7 @@ import { resolve } from 'path';
 import { defineConfig, LibraryOptions } from 'vite';
 import { InputOption } from 'rollup';
 
-type LibTypes = 'core' | 'plugin:sessions';
+type LibTypes = 'core' | 'plugin:sessions' | 'plugin:pubsub' | 'plugin:presence';
 
 const build = (process.env.BUILD as LibTypes) ?? 'core';
 
@@ -19,6 +19,18 @@ const makelib = () => ({
     formats: ['es', 'iife', 'umd'],
     fileName: (format) => `plugins/sessions/index.${format}.js`,
   },
+  'plugin:pubsub': {
+    entry: resolve(__dirname, 'src/plugins/pubsub/index.ts'),
+    name: 'EnzoPubSub',
+    formats: ['es', 'iife', 'umd'],
+    fileName: (format) => `plugins/pubsub/index.${format}.js`,
+  },
+  'plugin:presence': {
+    entry: resolve(__dirname, 'src/plugins/presence/index.ts'),
+    name: 'EnzoPresence',
+    formats: ['es', 'iife', 'umd'],
+    fileName: (format) => `plugins/presence/index.${format}.js`,
+  },
 }[build] as LibraryOptions);
 
 const makeinput = () => ({
@@ -28,6 +40,12 @@ const makeinput = () => ({
   'plugin:sessions': {
     'plugins/sessions/index': resolve(__dirname, 'src/plugins/sessions/index'),
   },
+  'plugin:pubsub': {
+    'plugins/pubsub/index': resolve(__dirname, 'src/plugins/pubsub/index'),
+  },
+  'plugin:presence': {
+    'plugins/presence/index': resolve(__dirname, 'src/plugins/presence/index'),
+  },
 }[build] as InputOption);
 
 // https://vitejs.dev/config/
@@ -0,0 +1,96 @@
+package enzogo
+
+import (
+	"errors"
+	"log"
+	"net"
+	"time"
+)
+
+// A client of a stream connection starts with a handshake frame listing the protocols it
+// speaks, comma separated. The server answers with a frame holding the chosen protocol,
+// or closes the transport with CloseProtocolError.
+
+// time given to a stream client for its handshake
+const handshakeTimeout = 10 * time.Second
+
+var ErrServerStopped = errors.New("server stopped")
+
+// Serve accepts stream connections, TCP or Unix sockets, and serves them like the websockets
+// of ServeHTTP until the listener fails or Stop is called, which returns ErrServerStopped.
+// Connect with Dial. The connections can not be resumed.
+func (enzo *Enzo) Serve(ln net.Listener) error {
+	enzo.lock.Lock()
+	enzo.listeners[ln] = struct{}{}
+	enzo.lock.Unlock()
+
+	var delay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			enzo.lock.Lock()
+			_, serving := enzo.listeners[ln]
+			if !serving {
+				enzo.lock.Unlock()
+				return ErrServerStopped
+			}
+
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				enzo.lock.Unlock()
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				log.Println("accept error:", err)
+				time.Sleep(delay)
+				continue
+			}
+
+			delete(enzo.listeners, ln)
+			enzo.lock.Unlock()
+			return err
+		}
+		delay = 0
+
+		go enzo.serveConn(conn)
+	}
+}
+
+func (enzo *Enzo) serveConn(conn net.Conn) {
+	t := NewConnTransport(conn)
+
+	t.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	offered, err := t.ReadFrame()
+	if err != nil {
+		log.Println("handshake error:", err)
+		conn.Close()
+		return
+	}
+	t.SetReadDeadline(time.Time{})
+
+	protocol, ok := enzo.chooseProtocol(string(offered))
+	if !ok {
+		log.Println("unsupported protocol:", string(offered))
+		t.Close(CloseProtocolError, "unsupported protocol")
+		return
+	}
+	if err := t.WriteFrame([]byte(protocol)); err != nil {
+		conn.Close()
+		return
+	}
+
+	enzo.serve(t, nil, protocol)
+}
+
+// closeListeners stops the Serve loops
+func (enzo *Enzo) closeListeners() {
+	enzo.lock.Lock()
+	defer enzo.lock.Unlock()
+
+	for ln := range enzo.listeners {
+		delete(enzo.listeners, ln)
+		ln.Close()
+	}
+}
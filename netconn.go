@@ -0,0 +1,135 @@
+package enzogo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// The stream transport, for TCP and Unix sockets. Every frame is | length(4) | frame(x) |,
+// a length of 0 starts a close record | code(2) | textLength(2) | text(x) |
+// which the other end echoes before closing the socket.
+
+// MaxStreamFrame is the largest frame read from a stream transport
+const MaxStreamFrame = 32 << 20
+
+var errFrameTooLarge = errors.New("frame too large")
+
+// NewConnTransport returns a transport over a stream connection, both ends use one
+func NewConnTransport(conn net.Conn) Transport {
+	return &connTransport{conn: conn, r: bufio.NewReader(conn)}
+}
+
+type connTransport struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	writeLock sync.Mutex
+	// a close record was written
+	closing bool
+}
+
+func (t *connTransport) ReadFrame() ([]byte, error) {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(t.r, head); err != nil {
+		return nil, err
+	}
+
+	n := binary.LittleEndian.Uint32(head)
+	if n == 0 {
+		return nil, t.readClose()
+	}
+	if n > MaxStreamFrame {
+		t.Close(ClosePolicyViolation, errFrameTooLarge.Error())
+		return nil, errFrameTooLarge
+	}
+
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(t.r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// readClose reads the close record of the other end, echoes it and closes the socket
+func (t *connTransport) readClose() error {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(t.r, head); err != nil {
+		return err
+	}
+	text := make([]byte, binary.LittleEndian.Uint16(head[2:]))
+	if _, err := io.ReadFull(t.r, text); err != nil {
+		return err
+	}
+	ce := &CloseError{Code: int(binary.LittleEndian.Uint16(head)), Text: string(text)}
+
+	t.writeLock.Lock()
+	if !t.closing {
+		t.closing = true
+		t.conn.Write(closeRecord(ce.Code, ce.Text))
+	}
+	t.writeLock.Unlock()
+
+	t.conn.Close()
+	return ce
+}
+
+func (t *connTransport) WriteFrame(frame []byte) error {
+	if len(frame) == 0 || len(frame) > MaxStreamFrame {
+		return errFrameTooLarge
+	}
+
+	t.writeLock.Lock()
+	defer t.writeLock.Unlock()
+
+	if t.closing {
+		return ErrConnectionClosed
+	}
+
+	bufs := net.Buffers{uint32Bytes(len(frame)), frame}
+	_, err := bufs.WriteTo(t.conn)
+	return err
+}
+
+func (t *connTransport) SetReadDeadline(d time.Time) error {
+	return t.conn.SetReadDeadline(d)
+}
+
+// Close writes a close record, the socket is closed once the other end echoes it or after a second
+func (t *connTransport) Close(code int, text string) error {
+	t.writeLock.Lock()
+	defer t.writeLock.Unlock()
+
+	if t.closing {
+		return nil
+	}
+	t.closing = true
+
+	if _, err := t.conn.Write(closeRecord(code, text)); err != nil {
+		t.conn.Close()
+		return err
+	}
+	time.AfterFunc(time.Second, func() {
+		t.conn.Close()
+	})
+	return nil
+}
+
+func (t *connTransport) RemoteAddr() net.Addr {
+	return t.conn.RemoteAddr()
+}
+
+func closeRecord(code int, text string) []byte {
+	if len(text) > 123 {
+		text = text[:123]
+	}
+
+	b := make([]byte, 8, 8+len(text))
+	binary.LittleEndian.PutUint16(b[4:], uint16(code))
+	binary.LittleEndian.PutUint16(b[6:], uint16(len(text)))
+	return append(b, text...)
+}
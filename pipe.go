@@ -0,0 +1,103 @@
+package enzogo
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// NewPipe returns the two ends of an in-memory transport, serve one with ServeTransport and
+// speak the enzo frames on the other. Closing either end closes both, the frames written
+// before are still read. Writes block while 64 frames wait for the reader.
+func NewPipe() (server, client Transport) {
+	a := make(chan []byte, 64)
+	b := make(chan []byte, 64)
+	state := &pipeState{done: make(chan struct{})}
+
+	return &pipeEnd{in: a, out: b, state: state}, &pipeEnd{in: b, out: a, state: state}
+}
+
+type pipeState struct {
+	once sync.Once
+	done chan struct{}
+	err  *CloseError
+}
+
+type pipeEnd struct {
+	in    <-chan []byte
+	out   chan<- []byte
+	state *pipeState
+
+	lock     sync.Mutex
+	deadline time.Time
+}
+
+func (p *pipeEnd) ReadFrame() ([]byte, error) {
+	p.lock.Lock()
+	deadline := p.deadline
+	p.lock.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case frame := <-p.in:
+		return frame, nil
+	case <-p.state.done:
+		select {
+		case frame := <-p.in:
+			return frame, nil
+		default:
+			return nil, p.state.err
+		}
+	case <-timeout:
+		return nil, errReadTimeout
+	}
+}
+
+func (p *pipeEnd) WriteFrame(frame []byte) error {
+	// the reader owns the frame
+	frame = append([]byte(nil), frame...)
+
+	select {
+	case <-p.state.done:
+		return ErrConnectionClosed
+	default:
+	}
+
+	select {
+	case p.out <- frame:
+		return nil
+	case <-p.state.done:
+		return ErrConnectionClosed
+	}
+}
+
+func (p *pipeEnd) SetReadDeadline(t time.Time) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.deadline = t
+	return nil
+}
+
+func (p *pipeEnd) Close(code int, text string) error {
+	p.state.once.Do(func() {
+		p.state.err = &CloseError{Code: code, Text: text}
+		close(p.state.done)
+	})
+	return nil
+}
+
+func (p *pipeEnd) RemoteAddr() net.Addr {
+	return pipeAddr{}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
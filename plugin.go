@@ -1,6 +1,395 @@
 package enzogo
 
+import (
+	"errors"
+	"log"
+	"strings"
+)
+
 type Plugin interface {
 	Name() string
 	Install(*Enzo)
 }
+
+// The optional hooks of a plugin
+
+// Uninstaller is called by Enzo.Uninstall to remove the handlers of the plugin
+type Uninstaller interface {
+	Uninstall(*Enzo)
+}
+
+// Dependent lists the names of the plugins that must be installed before it
+type Dependent interface {
+	Dependencies() []string
+}
+
+// Starter is called by Enzo.Start, or by Enzo.Use once Enzo is started
+type Starter interface {
+	Start(*Enzo) error
+}
+
+// Stopper is called by Enzo.Stop, and by Enzo.Uninstall of a started Enzo
+type Stopper interface {
+	Stop(*Enzo) error
+}
+
+// The connection hooks of a plugin, called by Enzo in the installation order of the plugins
+// (the reverse order for OnDisconnect). Unlike the listeners of Enzo.On they are not
+// removed by Enzo.Off.
+
+// ConnectHook is called for every new connection, before the "connect" listeners.
+// A resumed connection is not a new one.
+type ConnectHook interface {
+	OnConnect(*Context)
+}
+
+// DisconnectHook is called once a connection is gone, after the "disconnect" listeners
+type DisconnectHook interface {
+	OnDisconnect(*Context)
+}
+
+// MessageHook is called for every incoming message before its listeners,
+// the message is dropped when a hook returns false.
+type MessageHook interface {
+	OnMessage(*Context) bool
+}
+
+// ErrorHook is called when a frame can not be decoded or the socket fails
+type ErrorHook interface {
+	OnError(*Context, error)
+}
+
+// PluginHandler receives the PluginMessage frames addressed to the plugin,
+// they never reach the listeners of Enzo.On. See Context.PluginEmit for the other way.
+type PluginHandler interface {
+	ServePlugin(*Context)
+}
+
+// Use installs the plugins, the dependencies of a plugin are installed first whatever
+// the order of the arguments. Nothing is installed when a name is already taken,
+// a dependency is missing, the dependencies are circular or a plugin fails to start.
+func (enzo *Enzo) Use(plugins ...Plugin) error {
+	if plugins == nil {
+		return nil
+	}
+
+	enzo.pluginsOp.Lock()
+	defer enzo.pluginsOp.Unlock()
+
+	enzo.pluginsLock.RLock()
+	batch := map[string]Plugin{}
+	for _, p := range plugins {
+		name := p.Name()
+		if _, ok := enzo.plugins[name]; ok {
+			enzo.pluginsLock.RUnlock()
+			return errors.New("plugin \"" + name + "\" already installed")
+		}
+		if _, ok := batch[name]; ok {
+			enzo.pluginsLock.RUnlock()
+			return errors.New("plugin \"" + name + "\" used twice")
+		}
+		batch[name] = p
+	}
+
+	order, err := enzo.installOrder(plugins, batch)
+	started := enzo.started
+	enzo.pluginsLock.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	for i, p := range order {
+		log.Println("register plugin:", p.Name())
+		p.Install(enzo)
+		enzo.register(p)
+
+		if !started {
+			continue
+		}
+		if s, ok := p.(Starter); ok {
+			if err := s.Start(enzo); err != nil {
+				// the plugins of the batch are removed again, the failed one is not started
+				enzo.stopPlugins(order[:i])
+				enzo.removePlugins(order[:i+1])
+				return errors.New("plugin \"" + p.Name() + "\" start: " + err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+func (enzo *Enzo) register(p Plugin) {
+	enzo.pluginsLock.Lock()
+	defer enzo.pluginsLock.Unlock()
+
+	enzo.plugins[p.Name()] = p
+	enzo.pluginOrder = append(enzo.pluginOrder, p.Name())
+}
+
+// removePlugins uninstalls the plugins in the reverse order
+func (enzo *Enzo) removePlugins(list []Plugin) {
+	for i := len(list) - 1; i >= 0; i-- {
+		enzo.unregister(list[i].Name())
+		if u, ok := list[i].(Uninstaller); ok {
+			u.Uninstall(enzo)
+		}
+	}
+}
+
+func (enzo *Enzo) unregister(name string) {
+	enzo.pluginsLock.Lock()
+	defer enzo.pluginsLock.Unlock()
+
+	delete(enzo.plugins, name)
+	for i, n := range enzo.pluginOrder {
+		if n == name {
+			enzo.pluginOrder = append(enzo.pluginOrder[:i], enzo.pluginOrder[i+1:]...)
+			break
+		}
+	}
+}
+
+// installOrder sorts the batch so that every plugin follows its dependencies
+func (enzo *Enzo) installOrder(plugins []Plugin, batch map[string]Plugin) ([]Plugin, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	order := make([]Plugin, 0, len(plugins))
+	state := map[string]int{}
+
+	var visit func(p Plugin, path []string) error
+	visit = func(p Plugin, path []string) error {
+		name := p.Name()
+		path = append(path, name)
+
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			return errors.New("circular plugin dependencies: " + strings.Join(path, " -> "))
+		}
+		state[name] = visiting
+
+		if d, ok := p.(Dependent); ok {
+			for _, dep := range d.Dependencies() {
+				if _, ok := enzo.plugins[dep]; ok {
+					continue
+				}
+				q, ok := batch[dep]
+				if !ok {
+					return errors.New("plugin \"" + name + "\" depends on \"" + dep + "\" which is not installed")
+				}
+				if err := visit(q, path); err != nil {
+					return err
+				}
+			}
+		}
+
+		state[name] = visited
+		order = append(order, p)
+		return nil
+	}
+
+	for _, p := range plugins {
+		if err := visit(p, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+// Uninstall removes the plugin, it fails while another plugin depends on it
+func (enzo *Enzo) Uninstall(name string) error {
+	enzo.pluginsOp.Lock()
+	defer enzo.pluginsOp.Unlock()
+
+	enzo.pluginsLock.RLock()
+	p, ok := enzo.plugins[name]
+	if !ok {
+		enzo.pluginsLock.RUnlock()
+		return errors.New("plugin \"" + name + "\" not found")
+	}
+
+	for _, other := range enzo.plugins {
+		d, ok := other.(Dependent)
+		if !ok {
+			continue
+		}
+		for _, dep := range d.Dependencies() {
+			if dep == name {
+				enzo.pluginsLock.RUnlock()
+				return errors.New("plugin \"" + other.Name() + "\" depends on \"" + name + "\"")
+			}
+		}
+	}
+	started := enzo.started
+	enzo.pluginsLock.RUnlock()
+
+	var err error
+	if started {
+		err = enzo.stopPlugins([]Plugin{p})
+	}
+	enzo.removePlugins([]Plugin{p})
+
+	return err
+}
+
+// Start starts the plugins in the order they were installed, call it before serving.
+// The plugins already started are stopped again when one of them fails.
+func (enzo *Enzo) Start() error {
+	enzo.pluginsOp.Lock()
+	defer enzo.pluginsOp.Unlock()
+
+	enzo.pluginsLock.RLock()
+	started := enzo.started
+	enzo.pluginsLock.RUnlock()
+	if started {
+		return nil
+	}
+
+	list := enzo.installed()
+	for i, p := range list {
+		s, ok := p.(Starter)
+		if !ok {
+			continue
+		}
+		if err := s.Start(enzo); err != nil {
+			enzo.stopPlugins(list[:i])
+			return errors.New("plugin \"" + p.Name() + "\" start: " + err.Error())
+		}
+	}
+
+	enzo.pluginsLock.Lock()
+	enzo.started = true
+	enzo.pluginsLock.Unlock()
+
+	// again after a Stop
+	enzo.lock.Lock()
+	enzo.startHeartbeat()
+	enzo.lock.Unlock()
+	return nil
+}
+
+// Stop closes the listeners of Serve and every connection with CloseGoingAway, stops the
+// heartbeat of the cluster, then stops the plugins in the reverse order, and returns the first error
+func (enzo *Enzo) Stop() error {
+	enzo.closeListeners()
+	enzo.closeAll(CloseGoingAway, "shutdown")
+
+	enzo.lock.Lock()
+	enzo.stopHeartbeat()
+	enzo.lock.Unlock()
+
+	enzo.pluginsOp.Lock()
+	defer enzo.pluginsOp.Unlock()
+
+	enzo.pluginsLock.Lock()
+	started := enzo.started
+	enzo.started = false
+	enzo.pluginsLock.Unlock()
+	if !started {
+		return nil
+	}
+
+	return enzo.stopPlugins(enzo.installed())
+}
+
+// stopPlugins stops the plugins in the reverse order, it must be called without pluginsLock
+func (enzo *Enzo) stopPlugins(list []Plugin) error {
+	var first error
+	for i := len(list) - 1; i >= 0; i-- {
+		s, ok := list[i].(Stopper)
+		if !ok {
+			continue
+		}
+		if err := s.Stop(enzo); err != nil && first == nil {
+			first = errors.New("plugin \"" + list[i].Name() + "\" stop: " + err.Error())
+		}
+	}
+	return first
+}
+
+// installed returns the installed plugins in the order of installation
+func (enzo *Enzo) installed() []Plugin {
+	enzo.pluginsLock.RLock()
+	defer enzo.pluginsLock.RUnlock()
+
+	list := make([]Plugin, 0, len(enzo.pluginOrder))
+	for _, name := range enzo.pluginOrder {
+		list = append(list, enzo.plugins[name])
+	}
+	return list
+}
+
+func (enzo *Enzo) connected(ctx *Context) {
+	for _, p := range enzo.installed() {
+		if h, ok := p.(ConnectHook); ok {
+			h.OnConnect(ctx)
+		}
+	}
+
+	enzo.emitter.Emit("connect", ctx)
+}
+
+func (enzo *Enzo) disconnected(ctx *Context) {
+	enzo.emitter.Emit("disconnect", ctx)
+
+	list := enzo.installed()
+	for i := len(list) - 1; i >= 0; i-- {
+		if h, ok := list[i].(DisconnectHook); ok {
+			h.OnDisconnect(ctx)
+		}
+	}
+}
+
+// dispatch passes an incoming message to the message hooks, then to the listeners of its key,
+// or to the plugin it is addressed to
+func (enzo *Enzo) dispatch(ctx *Context) {
+	for _, p := range enzo.installed() {
+		if h, ok := p.(MessageHook); ok && !h.OnMessage(ctx) {
+			return
+		}
+	}
+
+	if ctx.payload.MsgType == PluginMessage {
+		enzo.servePlugin(ctx)
+		return
+	}
+
+	enzo.emitter.Emit(ctx.GetKey(), ctx)
+}
+
+func (enzo *Enzo) servePlugin(ctx *Context) {
+	name := ctx.GetKey()
+	if i := strings.IndexByte(name, '|'); i >= 0 {
+		name = name[:i]
+	}
+
+	h, ok := enzo.plugin(name).(PluginHandler)
+	if !ok {
+		// ! unhandled
+		log.Println("no plugin handles:", ctx.GetKey())
+		return
+	}
+	h.ServePlugin(ctx)
+}
+
+func (enzo *Enzo) failed(ctx *Context, err error) {
+	for _, p := range enzo.installed() {
+		if h, ok := p.(ErrorHook); ok {
+			h.OnError(ctx, err)
+		}
+	}
+}
+
+// plugin returns the installed plugin of the name, nil if not found
+func (enzo *Enzo) plugin(name string) Plugin {
+	enzo.pluginsLock.RLock()
+	defer enzo.pluginsLock.RUnlock()
+
+	return enzo.plugins[name]
+}
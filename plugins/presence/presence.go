@@ -0,0 +1,318 @@
+package presence
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	enzogo "github.com/cuipeiyu/enzo.go"
+)
+
+const pluginName = "presence"
+
+// status of a presence|change message: | status(1) | userID(x) |
+const (
+	statusOnline  byte = 0x01
+	statusOffline byte = 0x02
+)
+
+// New returns a presence plugin. A user whose last connection closes is only
+// reported offline after debounce, so a quick reconnect does not flap.
+func New(debounce time.Duration) *Presence {
+	return &Presence{
+		debounce: debounce,
+		users:    map[string]map[string]struct{}{},
+		conns:    map[string]string{},
+		watchers: map[string]map[string]struct{}{},
+		watching: map[string]map[string]struct{}{},
+		offline:  map[string]enzogo.Timer{},
+	}
+}
+
+func Load(ctx *enzogo.Context) (*Presence, error) {
+	p := ctx.GetPlugin(pluginName)
+	if p == nil {
+		return nil, errors.New("plugin \"" + pluginName + "\" not found")
+	}
+	t, ok := p.(*Presence)
+	if !ok {
+		return nil, errors.New("plugin \"" + pluginName + "\" not a Presence")
+	}
+	return t, nil
+}
+
+type Presence struct {
+	// Authorize allows a client to identify itself as the user, e.g. after checking the
+	// session of its request. Without it only the server binds users, with Identify.
+	Authorize func(ctx *enzogo.Context, userID string) bool
+
+	enzo     *enzogo.Enzo
+	debounce time.Duration
+
+	mux sync.Mutex
+	// connids of every online user
+	users map[string]map[string]struct{}
+	// user of every identified connection
+	conns map[string]string
+	// connids watching every user, and the users watched by every connid
+	watchers map[string]map[string]struct{}
+	watching map[string]map[string]struct{}
+	// users going offline once the timer fires
+	offline map[string]enzogo.Timer
+}
+
+func (p *Presence) Name() string {
+	return pluginName
+}
+
+func (p *Presence) Install(enzo *enzogo.Enzo) {
+	p.enzo = enzo
+}
+
+// ServePlugin handles the messages of the js plugin
+func (p *Presence) ServePlugin(ctx *enzogo.Context) {
+	name := p.Name()
+
+	switch ctx.GetKey() {
+	case name + "|identify":
+		p.onIdentify(ctx)
+	case name + "|watch":
+		p.onWatch(ctx)
+	case name + "|unwatch":
+		p.onUnwatch(ctx)
+	}
+}
+
+// Inspect tells the user of the connection
+func (p *Presence) Inspect(connid string) interface{} {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	return map[string]string{"user": p.conns[connid]}
+}
+
+// Uninstall forgets every user, nobody is notified
+func (p *Presence) Uninstall(enzo *enzogo.Enzo) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	for _, t := range p.offline {
+		t.Stop()
+	}
+	p.users = map[string]map[string]struct{}{}
+	p.conns = map[string]string{}
+	p.watchers = map[string]map[string]struct{}{}
+	p.watching = map[string]map[string]struct{}{}
+	p.offline = map[string]enzogo.Timer{}
+}
+
+// Identify binds the connection to a user, e.g. after an authentication done by the server
+func (p *Presence) Identify(ctx *enzogo.Context, userID string) {
+	connid := ctx.GetConnid()
+
+	p.mux.Lock()
+	if prev, ok := p.conns[connid]; ok {
+		if prev == userID {
+			p.mux.Unlock()
+			return
+		}
+		p.detach(connid, prev)
+	}
+
+	p.conns[connid] = userID
+	conns, online := p.users[userID]
+	if !online {
+		conns = map[string]struct{}{}
+		p.users[userID] = conns
+	}
+	conns[connid] = struct{}{}
+
+	// back before the debounce expired, nobody saw it leave
+	if t, ok := p.offline[userID]; ok {
+		t.Stop()
+		delete(p.offline, userID)
+		online = true
+	}
+	p.mux.Unlock()
+
+	if !online {
+		p.notify(userID, statusOnline)
+	}
+}
+
+// User returns the user of the connection, empty when not identified
+func (p *Presence) User(ctx *enzogo.Context) string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	return p.conns[ctx.GetConnid()]
+}
+
+// Online reports whether the user has at least one connection
+func (p *Presence) Online(userID string) bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	_, ok := p.users[userID]
+	return ok
+}
+
+// Users returns the online users
+func (p *Presence) Users() []string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	users := make([]string, 0, len(p.users))
+	for userID := range p.users {
+		users = append(users, userID)
+	}
+	return users
+}
+
+// List returns the online users with a connection in the room
+func (p *Presence) List(room string) []string {
+	connids := p.enzo.RoomConnids(room)
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	seen := map[string]struct{}{}
+	users := []string{}
+	for _, connid := range connids {
+		userID, ok := p.conns[connid]
+		if !ok {
+			continue
+		}
+		if _, ok := seen[userID]; ok {
+			continue
+		}
+		seen[userID] = struct{}{}
+		users = append(users, userID)
+	}
+	return users
+}
+
+// data: userID, refused unless Authorize allows it
+func (p *Presence) onIdentify(ctx *enzogo.Context) {
+	userID := string(ctx.GetData())
+	if userID == "" || p.Authorize == nil || !p.Authorize(ctx, userID) {
+		ctx.Write([]byte{statusOffline})
+		return
+	}
+
+	p.Identify(ctx, userID)
+	ctx.Write([]byte{statusOnline})
+}
+
+// data: userID, the reply is the current status of the user
+func (p *Presence) onWatch(ctx *enzogo.Context) {
+	connid := ctx.GetConnid()
+	userID := string(ctx.GetData())
+
+	p.mux.Lock()
+	watchers, ok := p.watchers[userID]
+	if !ok {
+		watchers = map[string]struct{}{}
+		p.watchers[userID] = watchers
+	}
+	watchers[connid] = struct{}{}
+
+	users, ok := p.watching[connid]
+	if !ok {
+		users = map[string]struct{}{}
+		p.watching[connid] = users
+	}
+	users[userID] = struct{}{}
+
+	_, online := p.users[userID]
+	p.mux.Unlock()
+
+	if online {
+		ctx.Write([]byte{statusOnline})
+	} else {
+		ctx.Write([]byte{statusOffline})
+	}
+}
+
+// data: userID
+func (p *Presence) onUnwatch(ctx *enzogo.Context) {
+	p.mux.Lock()
+	p.unwatch(ctx.GetConnid(), string(ctx.GetData()))
+	p.mux.Unlock()
+
+	ctx.Write(nil)
+}
+
+// OnDisconnect stops the watches of the connection, and detaches it from its user
+func (p *Presence) OnDisconnect(ctx *enzogo.Context) {
+	connid := ctx.GetConnid()
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	for userID := range p.watching[connid] {
+		p.unwatch(connid, userID)
+	}
+
+	if userID, ok := p.conns[connid]; ok {
+		p.detach(connid, userID)
+	}
+}
+
+// detach must be called with the lock held
+func (p *Presence) detach(connid, userID string) {
+	delete(p.conns, connid)
+
+	conns := p.users[userID]
+	delete(conns, connid)
+	if len(conns) > 0 {
+		return
+	}
+
+	var timer enzogo.Timer
+	timer = p.enzo.Clock().AfterFunc(p.debounce, func() {
+		p.mux.Lock()
+		// back meanwhile, maybe gone again with a newer timer
+		if p.offline[userID] != timer {
+			p.mux.Unlock()
+			return
+		}
+		delete(p.offline, userID)
+		delete(p.users, userID)
+		p.mux.Unlock()
+
+		p.notify(userID, statusOffline)
+	})
+	p.offline[userID] = timer
+}
+
+// unwatch must be called with the lock held
+func (p *Presence) unwatch(connid, userID string) {
+	if watchers, ok := p.watchers[userID]; ok {
+		delete(watchers, connid)
+		if len(watchers) == 0 {
+			delete(p.watchers, userID)
+		}
+	}
+	if users, ok := p.watching[connid]; ok {
+		delete(users, userID)
+		if len(users) == 0 {
+			delete(p.watching, connid)
+		}
+	}
+}
+
+// notify emits presence|change to the watchers of the user
+func (p *Presence) notify(userID string, status byte) {
+	p.mux.Lock()
+	connids := make([]string, 0, len(p.watchers[userID]))
+	for connid := range p.watchers[userID] {
+		connids = append(connids, connid)
+	}
+	p.mux.Unlock()
+
+	data := append([]byte{status}, userID...)
+	for _, connid := range connids {
+		p.enzo.PluginEmitTo(connid, pluginName+"|change", data)
+	}
+}
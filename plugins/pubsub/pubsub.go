@@ -0,0 +1,223 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"path"
+	"sync"
+
+	enzogo "github.com/cuipeiyu/enzo.go"
+)
+
+const pluginName = "pubsub"
+
+// operations checked by the authorization hooks
+const (
+	OpSubscribe = "subscribe"
+	OpPublish   = "publish"
+)
+
+// ErrForbidden is a convenient error for the authorization hooks
+var ErrForbidden = errors.New("forbidden")
+
+// AuthorizeFunc decides whether the connection may subscribe or publish to the topic
+type AuthorizeFunc func(ctx *enzogo.Context, op string, topic string) error
+
+type rule struct {
+	pattern string
+	fn      AuthorizeFunc
+}
+
+func New() *PubSub {
+	return &PubSub{}
+}
+
+func Load(ctx *enzogo.Context) (*PubSub, error) {
+	p := ctx.GetPlugin(pluginName)
+	if p == nil {
+		return nil, errors.New("plugin \"" + pluginName + "\" not found")
+	}
+	t, ok := p.(*PubSub)
+	if !ok {
+		return nil, errors.New("plugin \"" + pluginName + "\" not a PubSub")
+	}
+	return t, nil
+}
+
+// PubSub relays the messages of a topic to its subscribers, topics are rooms
+// of Enzo so publishing reaches the subscribers of every node of a cluster.
+type PubSub struct {
+	enzo *enzogo.Enzo
+
+	mux   sync.Mutex
+	rules []rule
+}
+
+func (p *PubSub) Name() string {
+	return pluginName
+}
+
+func (p *PubSub) Install(enzo *enzogo.Enzo) {
+	p.enzo = enzo
+}
+
+// ServePlugin handles the messages of the js plugin
+func (p *PubSub) ServePlugin(ctx *enzogo.Context) {
+	name := p.Name()
+
+	switch ctx.GetKey() {
+	case name + "|subscribe":
+		p.onSubscribe(ctx)
+	case name + "|unsubscribe":
+		p.onUnsubscribe(ctx)
+	case name + "|publish":
+		p.onPublish(ctx)
+	}
+}
+
+// Authorize adds a hook for the topics matching pattern (see path.Match), the hooks
+// of all matching patterns must pass. Topics without any matching hook are allowed.
+func (p *PubSub) Authorize(pattern string, fn AuthorizeFunc) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.rules = append(p.rules, rule{pattern, fn})
+	return nil
+}
+
+// Publish sends data to the subscribers of the topic
+func (p *PubSub) Publish(topic string, data []byte) error {
+	return p.enzo.PluginEmitRoom(room(topic), pluginName+"|message", messageBody(topic, data))
+}
+
+// Subscribe subscribes the connection to the topic, bypassing the authorization hooks
+func (p *PubSub) Subscribe(ctx *enzogo.Context, topic string) {
+	ctx.Join(room(topic))
+}
+
+// Unsubscribe unsubscribes the connection from the topic
+func (p *PubSub) Unsubscribe(ctx *enzogo.Context, topic string) {
+	ctx.Leave(room(topic))
+}
+
+func (p *PubSub) authorize(ctx *enzogo.Context, op, topic string) error {
+	p.mux.Lock()
+	rules := p.rules
+	p.mux.Unlock()
+
+	for _, r := range rules {
+		if ok, _ := path.Match(r.pattern, topic); !ok {
+			continue
+		}
+		if err := r.fn(ctx, op, topic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// data: topic
+func (p *PubSub) onSubscribe(ctx *enzogo.Context) {
+	topic := string(ctx.GetData())
+
+	if err := p.authorize(ctx, OpSubscribe, topic); err != nil {
+		ctx.Write(p.errorBody(err))
+		return
+	}
+
+	p.Subscribe(ctx, topic)
+	ctx.Write(p.normalBody(nil))
+}
+
+// data: topic
+func (p *PubSub) onUnsubscribe(ctx *enzogo.Context) {
+	p.Unsubscribe(ctx, string(ctx.GetData()))
+	ctx.Write(p.normalBody(nil))
+}
+
+// data: topiclen + topic + data
+func (p *PubSub) onPublish(ctx *enzogo.Context) {
+	topic, data, err := parseMessage(ctx.GetData())
+	if err != nil {
+		ctx.Write(p.errorBody(err))
+		return
+	}
+
+	if err := p.authorize(ctx, OpPublish, topic); err != nil {
+		ctx.Write(p.errorBody(err))
+		return
+	}
+
+	if err := p.Publish(topic, data); err != nil {
+		ctx.Write(p.errorBody(err))
+		return
+	}
+	ctx.Write(p.normalBody(nil))
+}
+
+func room(topic string) string {
+	return pluginName + ":" + topic
+}
+
+// topiclen + topic + data
+func messageBody(topic string, data []byte) []byte {
+	var buf bytes.Buffer
+
+	tl := make([]byte, 4)
+	binary.LittleEndian.PutUint32(tl, uint32(len(topic)))
+	buf.Write(tl)
+
+	buf.WriteString(topic)
+
+	buf.Write(data)
+
+	return buf.Bytes()
+}
+
+func parseMessage(raw []byte) (string, []byte, error) {
+	if len(raw) < 4 {
+		return "", nil, errors.New("incorrect data length")
+	}
+	tl := int(binary.LittleEndian.Uint32(raw[0:4]))
+	if len(raw) < 4+tl {
+		return "", nil, errors.New("incorrect data length")
+	}
+	return string(raw[4 : 4+tl]), raw[4+tl:], nil
+}
+
+func (p *PubSub) normalBody(data []byte) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteByte(0x01)
+
+	// data length
+	ml := make([]byte, 4)
+	binary.LittleEndian.PutUint32(ml, uint32(len(data)))
+	buf.Write(ml)
+
+	buf.Write(data)
+
+	return buf.Bytes()
+}
+
+func (p *PubSub) errorBody(err error) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteByte(0x02)
+
+	msg := err.Error()
+
+	// message length
+	ml := make([]byte, 4)
+	binary.LittleEndian.PutUint32(ml, uint32(len(msg)))
+	buf.Write(ml)
+
+	buf.WriteString(msg)
+
+	return buf.Bytes()
+}
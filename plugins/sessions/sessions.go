@@ -53,16 +53,47 @@ func (s *Sessions) Name() string {
 	return pluginName
 }
 
-func (s *Sessions) Install(enzo *enzogo.Enzo) {
+func (s *Sessions) Install(enzo *enzogo.Enzo) {}
+
+// ServePlugin handles the messages of the js plugin
+func (s *Sessions) ServePlugin(ctx *enzogo.Context) {
 	name := s.Name()
 
-	enzo.On(name+"|set", s.onSet)
-	enzo.On(name+"|get", s.onGet)
-	enzo.On(name+"|ttl", s.onTTL)
-	enzo.On(name+"|sizes", s.onSizes)
-	enzo.On(name+"|clean", s.onClean)
+	switch ctx.GetKey() {
+	case name + "|set":
+		s.onSet(ctx)
+	case name + "|get":
+		s.onGet(ctx)
+	case name + "|ttl":
+		s.onTTL(ctx)
+	case name + "|sizes":
+		s.onSizes(ctx)
+	case name + "|clean":
+		s.onClean(ctx)
+	}
+}
+
+// OnDisconnect drops the session of the connection
+func (s *Sessions) OnDisconnect(ctx *enzogo.Context) {
+	s.state.Delete(ctx.GetConnid())
+}
 
-	enzo.On("disconnect", s.remove)
+// Inspect tells the number of session keys of the connection
+func (s *Sessions) Inspect(connid string) interface{} {
+	var keys int64
+	if m, ok := s.state.Load(connid); ok {
+		keys = m.(Storage).Size()
+	}
+	return map[string]int64{"keys": keys}
+}
+
+// Uninstall drops the sessions of every connection
+func (s *Sessions) Uninstall(enzo *enzogo.Enzo) {
+	s.state.Range(func(connid, m interface{}) bool {
+		m.(Storage).RemoveAll()
+		s.state.Delete(connid)
+		return true
+	})
 }
 
 func (s *Sessions) onSet(ctx *enzogo.Context) {
@@ -183,11 +214,6 @@ func (s *Sessions) getStateMap(ctx *enzogo.Context) Storage {
 	return m.(Storage)
 }
 
-func (s *Sessions) remove(ctx *enzogo.Context) {
-	connid := ctx.GetConnid()
-	s.state.Delete(connid)
-}
-
 func (s *Sessions) normalBody(data []byte) []byte {
 	var buf bytes.Buffer
 
@@ -4,16 +4,28 @@ import (
 	"errors"
 	"sync"
 	"time"
+
+	enzogo "github.com/cuipeiyu/enzo.go"
 )
 
 func New() *Memory {
-	return &Memory{data: map[string]*data{}}
+	return NewWithClock(nil)
+}
+
+// NewWithClock returns a storage expiring the keys on the clock, e.g. the one of Enzo.Clock,
+// nil for the system clock
+func NewWithClock(clock enzogo.Clock) *Memory {
+	if clock == nil {
+		clock = enzogo.SystemClock
+	}
+	return &Memory{data: map[string]*data{}, clock: clock}
 }
 
 type Memory struct {
-	size int64
-	mux  sync.Mutex
-	data map[string]*data
+	size  int64
+	mux   sync.Mutex
+	data  map[string]*data
+	clock enzogo.Clock
 }
 
 func (m *Memory) Size() int64 {
@@ -42,13 +54,7 @@ func (m *Memory) Set(key string, val []byte, ttl int) error {
 	}
 
 	if ttl > 0 {
-		t := time.Now().Add(time.Duration(ttl) * time.Second)
-		d.expire = &t
-		d.timer = time.AfterFunc(time.Duration(ttl)*time.Second, func() {
-			m.size--
-			d.removeTime()
-			delete(m.data, key)
-		})
+		m.expire(key, d, ttl)
 	}
 
 	if !ok {
@@ -69,7 +75,7 @@ func (m *Memory) Get(key string) (val []byte, err error) {
 	}
 
 	// already expired
-	if d.expire != nil && time.Now().After(*d.expire) {
+	if d.expire != nil && m.clock.Now().After(*d.expire) {
 		m.size--
 		d.removeTime()
 		delete(m.data, key)
@@ -115,7 +121,7 @@ func (m *Memory) TTL(key string, ttl int) error {
 	}
 
 	// already expired
-	if d.expire != nil && time.Now().After(*d.expire) {
+	if d.expire != nil && m.clock.Now().After(*d.expire) {
 		m.size--
 		d.removeTime()
 		delete(m.data, key)
@@ -131,18 +137,37 @@ func (m *Memory) TTL(key string, ttl int) error {
 	}
 
 	if ttl > 0 {
-		t := time.Now().Add(time.Duration(ttl) * time.Second)
-		d.expire = &t
-		d.timer = time.AfterFunc(time.Duration(ttl)*time.Second, func() {
-			m.size--
-			d.removeTime()
-			delete(m.data, key)
-		})
+		m.expire(key, d, ttl)
 	}
 
 	return nil
 }
 
+// expire removes the key after ttl seconds, it must be called with the lock held
+func (m *Memory) expire(key string, d *data, ttl int) {
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+
+	t := m.clock.Now().Add(time.Duration(ttl) * time.Second)
+	d.expire = &t
+
+	var timer enzogo.Timer
+	timer = m.clock.AfterFunc(time.Duration(ttl)*time.Second, func() {
+		m.mux.Lock()
+		defer m.mux.Unlock()
+
+		// deleted or given another ttl meanwhile
+		if m.data[key] != d || d.timer != timer {
+			return
+		}
+		m.size--
+		d.removeTime()
+		delete(m.data, key)
+	})
+	d.timer = timer
+}
+
 func (m *Memory) RemoveAll() error {
 	m.mux.Lock()
 	defer m.mux.Unlock()
@@ -159,7 +184,7 @@ func (m *Memory) RemoveAll() error {
 type data struct {
 	data   []byte
 	expire *time.Time
-	timer  *time.Timer
+	timer  enzogo.Timer
 }
 
 func (d *data) removeTime() {
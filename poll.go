@@ -0,0 +1,354 @@
+package enzogo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The long-polling transport, for the clients behind proxies which break websockets.
+// Every request carries ?enzo_transport=poll, the frames of a body are | length(4) | frame(x) |.
+//
+//	POST   (no enzo_sid)   open, ?enzo_protocol=<offered versions, comma separated>,
+//	                       replies {"sid": "...", "protocol": "..."}
+//	GET    &enzo_sid=...   wait for the frames of the server, 204 when there is none yet,
+//	                       &enzo_ack=<count of the frames received so far>, the frames not
+//	                       acknowledged are sent again
+//	POST   &enzo_sid=...   send frames to the server
+//	DELETE &enzo_sid=...   close, &enzo_code=<close code> (default 1000)
+//
+// Once the transport is closed a GET replies 410 Gone with {"code": 1000, "reason": "..."}.
+const (
+	transportParam = "enzo_transport"
+	sessionParam   = "enzo_sid"
+	protocolParam  = "enzo_protocol"
+	closeCodeParam = "enzo_code"
+	ackParam       = "enzo_ack"
+
+	pollTimeout = 25 * time.Second
+	// a client which does not poll for this long is gone
+	pollGone = 2 * pollTimeout
+	// the body of a POST is limited
+	maxPollBody = 16 << 20
+)
+
+var errPollGone = errors.New("poll client gone")
+
+// EnablePolling accepts the long-polling transport on the same handler, the js sdk
+// falls back to it when the websocket can not be opened.
+func (enzo *Enzo) EnablePolling() {
+	enzo.lock.Lock()
+	defer enzo.lock.Unlock()
+
+	enzo.polling = true
+}
+
+// servePoll handles the requests of the long-polling transport
+func (enzo *Enzo) servePoll(rw http.ResponseWriter, r *http.Request) {
+	sid := r.URL.Query().Get(sessionParam)
+	if sid == "" {
+		if r.Method != http.MethodPost {
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		enzo.openPoll(rw, r)
+		return
+	}
+
+	v, ok := enzo.polls.Load(sid)
+	if !ok {
+		http.Error(rw, "unknown session", http.StatusNotFound)
+		return
+	}
+	t := v.(*pollTransport)
+
+	switch r.Method {
+	case http.MethodGet:
+		t.poll(rw, r)
+	case http.MethodPost:
+		t.receive(rw, r)
+	case http.MethodDelete:
+		code, err := strconv.Atoi(r.URL.Query().Get(closeCodeParam))
+		if err != nil {
+			code = CloseNormalClosure
+		}
+		t.closeWith(&CloseError{Code: code})
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (enzo *Enzo) openPoll(rw http.ResponseWriter, r *http.Request) {
+	protocol, ok := enzo.pollProtocol(r)
+	if !ok {
+		log.Println("unsupported protocol:", r.URL.Query().Get(protocolParam))
+		writeClose(rw, CloseProtocolError, "unsupported protocol")
+		return
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	sid := hex.EncodeToString(b)
+
+	t := &pollTransport{
+		incoming: make(chan []byte, 64),
+		ready:    make(chan struct{}, 1),
+		closed:   make(chan struct{}),
+		addr:     requestAddr(r.RemoteAddr),
+		clock:    enzo.clock,
+	}
+	t.forget = func() {
+		enzo.polls.Delete(sid)
+	}
+	t.gone = enzo.clock.AfterFunc(pollGone, func() {
+		t.closeWith(errPollGone)
+		t.forget()
+	})
+	enzo.polls.Store(sid, t)
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(map[string]string{"sid": sid, "protocol": protocol})
+
+	go enzo.serve(t, r.Clone(context.Background()), protocol)
+}
+
+// pollProtocol picks the protocol offered in the query
+func (enzo *Enzo) pollProtocol(r *http.Request) (string, bool) {
+	return enzo.chooseProtocol(r.URL.Query().Get(protocolParam))
+}
+
+// pollTransport queues the frames of the server until the client polls them
+type pollTransport struct {
+	incoming chan []byte
+
+	lock sync.Mutex
+	// frames not acknowledged by the client yet, the first one follows acked
+	outgoing [][]byte
+	acked    uint64
+	// frames written to the polls so far
+	written  uint64
+	deadline time.Time
+	// signalled when outgoing gets frames
+	ready chan struct{}
+
+	closed    chan struct{}
+	closeErr  error
+	closeOnce sync.Once
+
+	// fires when the client stops polling
+	gone   Timer
+	forget func()
+	clock  Clock
+
+	// address of the client which opened the session
+	addr requestAddr
+}
+
+func (t *pollTransport) ReadFrame() ([]byte, error) {
+	t.lock.Lock()
+	deadline := t.deadline
+	t.lock.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case p := <-t.incoming:
+		return p, nil
+	case <-t.closed:
+		return nil, t.closeErr
+	case <-timeout:
+		return nil, errReadTimeout
+	}
+}
+
+func (t *pollTransport) WriteFrame(frame []byte) error {
+	select {
+	case <-t.closed:
+		return ErrConnectionClosed
+	default:
+	}
+
+	t.lock.Lock()
+	if len(t.outgoing) >= maxPending {
+		t.lock.Unlock()
+		// the client does not keep up
+		t.closeWith(&CloseError{Code: ClosePolicyViolation, Text: "too many pending frames"})
+		return ErrConnectionClosed
+	}
+	t.outgoing = append(t.outgoing, frame)
+	t.lock.Unlock()
+
+	select {
+	case t.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (t *pollTransport) SetReadDeadline(d time.Time) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.deadline = d
+	return nil
+}
+
+// Close ends the transport, the next poll tells the client the code and text
+func (t *pollTransport) Close(code int, text string) error {
+	t.closeWith(&CloseError{Code: code, Text: text})
+	return nil
+}
+
+func (t *pollTransport) RemoteAddr() net.Addr {
+	return t.addr
+}
+
+func (t *pollTransport) closeWith(err error) {
+	t.closeOnce.Do(func() {
+		t.closeErr = err
+		close(t.closed)
+	})
+}
+
+// requestAddr is the remote address of an http request
+type requestAddr string
+
+func (a requestAddr) Network() string { return "tcp" }
+func (a requestAddr) String() string  { return string(a) }
+
+// poll waits for the frames of the server
+func (t *pollTransport) poll(rw http.ResponseWriter, r *http.Request) {
+	t.gone.Reset(pollGone)
+	defer t.gone.Reset(pollGone)
+
+	t.lock.Lock()
+	t.acknowledge(r)
+	waiting := len(t.outgoing) == 0
+	t.lock.Unlock()
+
+	// the frames not acknowledged are sent again at once
+	if waiting {
+		timeout, timer := after(t.clock, pollTimeout)
+		defer timer.Stop()
+
+		select {
+		case <-t.ready:
+		case <-t.closed:
+		case <-timeout:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	t.lock.Lock()
+	frames := append([][]byte(nil), t.outgoing...)
+	t.written = t.acked + uint64(len(frames))
+	t.lock.Unlock()
+
+	if len(frames) == 0 {
+		select {
+		case <-t.closed:
+			t.gone.Stop()
+			t.forget()
+			code, text := CloseAbnormalClosure, ""
+			if ce, ok := t.closeErr.(*CloseError); ok {
+				code, text = ce.Code, ce.Text
+			}
+			writeClose(rw, code, text)
+		default:
+			rw.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	for _, frame := range frames {
+		rw.Write(uint32Bytes(len(frame)))
+		rw.Write(frame)
+	}
+}
+
+// acknowledge drops the frames received by the client, it must be called with the lock held.
+// A poll without enzo_ack acknowledges every frame written before.
+func (t *pollTransport) acknowledge(r *http.Request) {
+	ack := t.written
+	if v := r.URL.Query().Get(ackParam); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err == nil && n < t.written {
+			ack = n
+		}
+	}
+	if ack <= t.acked {
+		return
+	}
+
+	n := int(ack - t.acked)
+	for i := 0; i < n; i++ {
+		t.outgoing[i] = nil
+	}
+	t.outgoing = t.outgoing[n:]
+	t.acked = ack
+}
+
+// receive reads the frames sent by the client
+func (t *pollTransport) receive(rw http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPollBody))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for len(body) > 0 {
+		if len(body) < 4 {
+			http.Error(rw, errMismatchedLength.Error(), http.StatusBadRequest)
+			return
+		}
+		l := int(binary.LittleEndian.Uint32(body[:4]))
+		if len(body) < 4+l {
+			http.Error(rw, errMismatchedLength.Error(), http.StatusBadRequest)
+			return
+		}
+		frame := body[4 : 4+l]
+		body = body[4+l:]
+
+		select {
+		case t.incoming <- frame:
+		case <-t.closed:
+			http.Error(rw, ErrConnectionClosed.Error(), http.StatusGone)
+			return
+		}
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func writeClose(rw http.ResponseWriter, code int, text string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusGone)
+	json.NewEncoder(rw).Encode(map[string]interface{}{"code": code, "reason": text})
+}
+
+// errReadTimeout is the net.Error of a read past the deadline
+var errReadTimeout error = timeoutError{}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
@@ -0,0 +1,104 @@
+package enzogo
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	ProtocolV0 = "enzo-v0"
+	// ProtocolV1 adds a header section to every frame
+	ProtocolV1 = "enzo-v1"
+	// ProtocolV2 adds a sequence number to every frame but ping and pong
+	ProtocolV2 = "enzo-v2"
+)
+
+// features of a protocol version
+type features struct {
+	header bool
+	seq    bool
+}
+
+var knownProtocols = map[string]features{
+	ProtocolV0: {},
+	ProtocolV1: {header: true},
+	ProtocolV2: {header: true, seq: true},
+}
+
+// SetProtocols sets the protocol versions accepted by the server, in order of preference.
+func (enzo *Enzo) SetProtocols(versions ...string) error {
+	if len(versions) == 0 {
+		return errors.New("at least one protocol is required")
+	}
+	for _, v := range versions {
+		if _, ok := knownProtocols[v]; !ok {
+			return errors.New("unknown protocol \"" + v + "\"")
+		}
+	}
+
+	enzo.lock.Lock()
+	defer enzo.lock.Unlock()
+
+	enzo.upgrader.Subprotocols = append([]string(nil), versions...)
+	return nil
+}
+
+// protocols returns the protocol versions accepted by the server, they may change while serving
+func (enzo *Enzo) protocols() []string {
+	enzo.lock.Lock()
+	defer enzo.lock.Unlock()
+
+	return append([]string(nil), enzo.upgrader.Subprotocols...)
+}
+
+func (enzo *Enzo) supportsProtocol(version string) bool {
+	for _, v := range enzo.protocols() {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateProtocol returns the protocol spoken on an upgraded connection,
+// or false when the client did not offer any version supported by the server.
+func (enzo *Enzo) negotiateProtocol(conn *websocket.Conn, r *http.Request) (string, bool) {
+	if p := conn.Subprotocol(); p != "" {
+		return p, true
+	}
+
+	// clients without a subprotocol predate the negotiation and speak enzo-v0
+	if len(websocket.Subprotocols(r)) == 0 && enzo.supportsProtocol(ProtocolV0) {
+		return ProtocolV0, true
+	}
+
+	return "", false
+}
+
+// chooseProtocol picks the protocol like the websocket upgrader, in the order of preference of the server.
+// offered is a comma separated list, a client offering nothing speaks enzo-v0.
+func (enzo *Enzo) chooseProtocol(offered string) (string, bool) {
+	if offered == "" {
+		return ProtocolV0, enzo.supportsProtocol(ProtocolV0)
+	}
+
+	for _, v := range enzo.protocols() {
+		for _, o := range strings.Split(offered, ",") {
+			if strings.TrimSpace(o) == v {
+				return v, true
+			}
+		}
+	}
+	return "", false
+}
+
+// rejectProtocol closes a connection with an unsupported protocol
+func rejectProtocol(conn *websocket.Conn) {
+	msg := websocket.FormatCloseMessage(websocket.CloseProtocolError, "unsupported protocol")
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	conn.Close()
+}
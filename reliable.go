@@ -0,0 +1,217 @@
+package enzogo
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// maximum number of unacknowledged reliable messages of a connection
+	maxOutbox = 1024
+
+	// how long a received message id is remembered to drop its retries
+	dedupWindow = time.Minute
+	// received message ids kept in one generation
+	maxSeen = 256
+
+	// header of the messages sent again until they are replied, the retries of the
+	// messages without it are not detected
+	reliableHeader = "Enzo-Reliable"
+)
+
+var ErrOutboxFull = errors.New("outbox full")
+
+// RetryPolicy controls the redelivery of ReliableEmit
+type RetryPolicy struct {
+	// number of attempts before the delivery fails
+	Attempts int
+	// delay before the first retry, doubled after every attempt
+	Backoff time.Duration
+	// upper bound of the delay
+	MaxBackoff time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:   5,
+	Backoff:    time.Second,
+	MaxBackoff: 30 * time.Second,
+}
+
+// SetRetryPolicy sets the redelivery of ReliableEmit
+func (enzo *Enzo) SetRetryPolicy(policy RetryPolicy) error {
+	if policy.Attempts < 1 {
+		return errors.New("at least one attempt is required")
+	}
+
+	enzo.lock.Lock()
+	defer enzo.lock.Unlock()
+
+	enzo.retryPolicy = policy
+	return nil
+}
+
+// outgoing reliable message, waiting for its reply
+type outgoing struct {
+	msgid    []byte
+	key      string
+	data     []byte
+	callback Handle
+}
+
+// ReliableEmit is like Emit, but the message is kept in the outbox of the connection and
+// sent again with the same message id until the client replies, so the client handles it
+// at most once and receives it at least once. When every attempt failed, Enzo.DeliveryFailed
+// is called and the callback receives the error. The clients speaking enzo-v0 can not tell
+// the retries, they may handle the message more than once.
+func (ctx *Context) ReliableEmit(key string, data []byte, cb ...Handle) error {
+	if ctx.transport == nil {
+		return ErrConnectionClosed
+	}
+
+	var callback Handle
+
+	if cb == nil {
+		callback = func(ctx *Context) {}
+	} else {
+		callback = cb[0]
+	}
+
+	o := &outgoing{
+		msgid:    makeMsgId(),
+		key:      key,
+		data:     data,
+		callback: callback,
+	}
+
+	if !ctx.conn.enqueue(o) {
+		return ErrOutboxFull
+	}
+
+	go ctx.deliver(o)
+
+	return nil
+}
+
+func (ctx *Context) deliver(o *outgoing) {
+	ctx.enzo.lock.Lock()
+	policy := ctx.enzo.retryPolicy
+	ctx.enzo.lock.Unlock()
+	backoff := policy.Backoff
+
+	for attempt := 1; ; attempt++ {
+		replies := make(chan *Context, 1)
+		header := ctx.headers()
+		if header == nil {
+			header = Header{}
+		}
+		header.Set(reliableHeader, "1")
+
+		ctx.writeHeader(PostMessage, false, o.msgid, o.key, header, o.data, func(res *Context) {
+			replies <- res
+		})
+
+		res := <-replies
+		if !res.IsError() {
+			ctx.conn.dequeue(o.msgid)
+			o.callback(res)
+			return
+		}
+
+		if attempt >= policy.Attempts {
+			ctx.fail(o, res.Error())
+			return
+		}
+
+		wait, timer := after(ctx.enzo.clock, backoff)
+		select {
+		case <-wait:
+		case <-ctx.conn.resumed():
+			timer.Stop()
+		case <-ctx.conn.ctx.Done():
+			timer.Stop()
+			ctx.fail(o, ErrConnectionClosed)
+			return
+		}
+
+		backoff *= 2
+		if backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+func (ctx *Context) fail(o *outgoing, err error) {
+	ctx.conn.dequeue(o.msgid)
+
+	failed := ctx.errorContext(err)
+	failed.payload = payload{
+		MsgType: PostMessage,
+		MsgID:   o.msgid,
+		Key:     o.key,
+		Data:    o.data,
+	}
+
+	if ctx.enzo.DeliveryFailed != nil {
+		ctx.enzo.DeliveryFailed(failed)
+	}
+	o.callback(failed)
+}
+
+func (c *connection) enqueue(o *outgoing) bool {
+	c.outboxLock.Lock()
+	defer c.outboxLock.Unlock()
+
+	if len(c.outbox) >= maxOutbox {
+		return false
+	}
+	if c.outbox == nil {
+		c.outbox = map[string]*outgoing{}
+	}
+	c.outbox[bytes2BHex(o.msgid)] = o
+	return true
+}
+
+func (c *connection) dequeue(msgid []byte) {
+	c.outboxLock.Lock()
+	defer c.outboxLock.Unlock()
+
+	delete(c.outbox, bytes2BHex(msgid))
+}
+
+// received reports whether the reliable message was already received, and returns its reply if any.
+// Message ids are kept for up to dedupWindow, in two generations swapped on expiry
+// or once a generation holds maxSeen ids.
+func (c *connection) received(msgid []byte, now time.Time) (bool, []byte) {
+	c.seenLock.Lock()
+	defer c.seenLock.Unlock()
+
+	if now.Sub(c.seenAt) > dedupWindow || len(c.seen) >= maxSeen {
+		c.seenPrev = c.seen
+		c.seen = map[string][]byte{}
+		c.seenAt = now
+	}
+
+	id := bytes2BHex(msgid)
+	if reply, ok := c.seen[id]; ok {
+		return true, reply
+	}
+	if reply, ok := c.seenPrev[id]; ok {
+		return true, reply
+	}
+
+	c.seen[id] = nil
+	return false, nil
+}
+
+// remember keeps the reply of a received message for its retries
+func (c *connection) remember(msgid []byte, reply []byte) {
+	c.seenLock.Lock()
+	defer c.seenLock.Unlock()
+
+	id := bytes2BHex(msgid)
+	if _, ok := c.seen[id]; ok {
+		c.seen[id] = reply
+	} else if _, ok := c.seenPrev[id]; ok {
+		c.seenPrev[id] = reply
+	}
+}
@@ -0,0 +1,181 @@
+package enzogo
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	// query parameter carrying the resume token of a reconnecting client
+	resumeParam = "enzo_resume"
+
+	// maximum number of frames buffered while the client is away
+	maxPending = 1024
+)
+
+// EnableResume keeps a closed connection for the grace period. A client reconnecting with
+// its resume token within that time reclaims its connid, session storage, reliable outbox
+// and the frames written while it was away; the "resume" event is emitted instead of "connect".
+// "disconnect" is emitted once the grace period expires, or immediately on a normal closure.
+func (enzo *Enzo) EnableResume(grace time.Duration) error {
+	if grace <= 0 {
+		return errors.New("invalid grace period")
+	}
+
+	enzo.lock.Lock()
+	defer enzo.lock.Unlock()
+
+	enzo.resumeGrace = grace
+	return nil
+}
+
+// issueToken registers the connection for resumption and sends its new token to the client
+func (enzo *Enzo) issueToken(c *connection) {
+	b := make([]byte, 16)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+
+	enzo.resumeLock.Lock()
+	if c.token != "" {
+		delete(enzo.resumable, c.token)
+	}
+	c.token = token
+	enzo.resumable[token] = c
+	enzo.resumeLock.Unlock()
+
+	ctx := connContext(enzo, c)
+	ctx.write(ResumeMessage, false, nil, c.id, []byte(token), func(ctx *Context) {})
+}
+
+// reclaim returns the connection of a resume token, live or within its grace period
+func (enzo *Enzo) reclaim(token string) *connection {
+	if token == "" {
+		return nil
+	}
+
+	enzo.resumeLock.Lock()
+	defer enzo.resumeLock.Unlock()
+
+	c, ok := enzo.resumable[token]
+	if !ok {
+		return nil
+	}
+	delete(enzo.resumable, token)
+	c.token = ""
+
+	return c
+}
+
+// closed is called when the socket of a connection is gone,
+// gen is the generation of the connection when the socket was attached.
+func (enzo *Enzo) closed(c *connection, gen int, err error) {
+	c.writeLock.Lock()
+	if c.generation != gen {
+		// already taken over by a resumed socket
+		c.writeLock.Unlock()
+		return
+	}
+	if c.reason == nil || !c.reason.Server {
+		c.reason = reasonOf(err)
+	}
+	final := c.grace <= 0 || c.reason.Server ||
+		isCloseError(err, CloseNormalClosure, CloseGoingAway)
+	if !final {
+		c.detached = true
+		c.detachedSeq = c.outSeq
+	}
+	c.writeLock.Unlock()
+
+	if final {
+		enzo.disconnect(c)
+		return
+	}
+
+	enzo.resumeLock.Lock()
+	token := c.token
+	enzo.resumeLock.Unlock()
+
+	enzo.clock.AfterFunc(c.grace, func() {
+		enzo.resumeLock.Lock()
+		if enzo.resumable[token] != c {
+			// resumed in time
+			enzo.resumeLock.Unlock()
+			return
+		}
+		delete(enzo.resumable, token)
+		enzo.resumeLock.Unlock()
+
+		enzo.disconnect(c)
+	})
+}
+
+// disconnect ends a connection for good
+func (enzo *Enzo) disconnect(c *connection) {
+	enzo.resumeLock.Lock()
+	if c.token != "" && enzo.resumable[c.token] == c {
+		delete(enzo.resumable, c.token)
+	}
+	enzo.resumeLock.Unlock()
+
+	// nothing can be written to a connection which is gone
+	enzo.disconnected(&Context{
+		enzo: enzo,
+		conn: c,
+	})
+
+	enzo.conns.Delete(c.id)
+	enzo.leaveAll(c)
+	c.dropTransfers()
+	enzo.announce(EnvelopeDisown, c.id)
+	c.cancel()
+}
+
+// attach binds a new socket to the connection and flushes the frames buffered meanwhile,
+// or with enzo-v2, replays the frames sent after lastSeq, the last one received by the client.
+// Without a lastSeq, or when the new socket speaks an older protocol, only the frames written
+// while the client was away are sent. It returns the previous socket when it was still open.
+func (c *connection) attach(t Transport, protocol string, lastSeq uint64, hasSeq bool) Transport {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	var old Transport
+	if !c.detached {
+		old = c.transport
+		c.detachedSeq = c.outSeq
+	}
+
+	from := c.features()
+	c.transport = t
+	c.protocol = protocol
+	c.detached = false
+	c.reason = nil
+	c.generation++
+	to := c.features()
+
+	if from.seq {
+		if !hasSeq || !to.seq {
+			lastSeq = c.detachedSeq
+		}
+		c.replay(lastSeq, from, to)
+	}
+	for _, frame := range c.pending {
+		c.writeFrame(c.convert(frame, from, to))
+	}
+	c.pending = nil
+
+	// wake up the reliable deliveries waiting for a retry
+	close(c.wake)
+	c.wake = make(chan struct{})
+
+	return old
+}
+
+// resumed returns a channel closed on the next attach of a socket
+func (c *connection) resumed() <-chan struct{} {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	return c.wake
+}
@@ -0,0 +1,85 @@
+package enzogo
+
+// Join adds the connection to a room, see Enzo.EmitRoom
+func (ctx *Context) Join(room string) {
+	enzo := ctx.enzo
+
+	enzo.roomsLock.Lock()
+	defer enzo.roomsLock.Unlock()
+
+	members, ok := enzo.rooms[room]
+	if !ok {
+		members = map[string]*connection{}
+		enzo.rooms[room] = members
+	}
+	members[ctx.conn.id] = ctx.conn
+
+	if ctx.conn.rooms == nil {
+		ctx.conn.rooms = map[string]struct{}{}
+	}
+	ctx.conn.rooms[room] = struct{}{}
+}
+
+// Leave removes the connection from a room
+func (ctx *Context) Leave(room string) {
+	ctx.enzo.roomsLock.Lock()
+	defer ctx.enzo.roomsLock.Unlock()
+
+	ctx.enzo.leave(ctx.conn, room)
+}
+
+// Rooms returns the rooms joined by the connection
+func (ctx *Context) Rooms() []string {
+	ctx.enzo.roomsLock.Lock()
+	defer ctx.enzo.roomsLock.Unlock()
+
+	rooms := make([]string, 0, len(ctx.conn.rooms))
+	for room := range ctx.conn.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// leave must be called with the rooms lock held
+func (enzo *Enzo) leave(c *connection, room string) {
+	if members, ok := enzo.rooms[room]; ok {
+		delete(members, c.id)
+		if len(members) == 0 {
+			delete(enzo.rooms, room)
+		}
+	}
+	delete(c.rooms, room)
+}
+
+// leaveAll removes a closed connection from all its rooms
+func (enzo *Enzo) leaveAll(c *connection) {
+	enzo.roomsLock.Lock()
+	defer enzo.roomsLock.Unlock()
+
+	for room := range c.rooms {
+		enzo.leave(c, room)
+	}
+}
+
+// roomMembers returns the local connections of a room
+func (enzo *Enzo) roomMembers(room string) []*connection {
+	enzo.roomsLock.Lock()
+	defer enzo.roomsLock.Unlock()
+
+	members := make([]*connection, 0, len(enzo.rooms[room]))
+	for _, c := range enzo.rooms[room] {
+		members = append(members, c)
+	}
+	return members
+}
+
+// RoomConnids returns the ids of the connections of this node in a room
+func (enzo *Enzo) RoomConnids(room string) []string {
+	members := enzo.roomMembers(room)
+
+	connids := make([]string, 0, len(members))
+	for _, c := range members {
+		connids = append(connids, c.id)
+	}
+	return connids
+}
@@ -0,0 +1,245 @@
+package enzogo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// kinds of Envelope used to route requests between nodes
+const (
+	EnvelopeReply     = "reply"
+	EnvelopeOwn       = "own"
+	EnvelopeDisown    = "disown"
+	EnvelopeHeartbeat = "heartbeat"
+)
+
+const (
+	heartbeatInterval = 5 * time.Second
+	// a node without heartbeat for this long is gone
+	nodeTimeout = 3 * heartbeatInterval
+	// the owner node replies the timeout of its client, so a remote request only times out
+	// when that node is lost without being found gone first, the nodes expire with the heartbeats
+	remoteTimeout = nodeTimeout + 2*heartbeatInterval
+)
+
+var ErrNodeGone = errors.New("node gone")
+
+// a request emitted to a connection of another node, waiting for its reply
+type remoteCall struct {
+	node     string
+	connid   string
+	callback Handle
+	timer    Timer
+}
+
+// emitRemote emits the envelope to the connection of another node targeted by it,
+// the reply is routed back through the broker
+func (enzo *Enzo) emitRemote(e Envelope, callback Handle) error {
+	connid := e.Target
+
+	enzo.clusterLock.Lock()
+	node, ok := enzo.owners[connid]
+	if !ok {
+		enzo.clusterLock.Unlock()
+		return ErrConnNotFound
+	}
+
+	id := bytes2BHex(makeMsgId())
+	call := &remoteCall{
+		node:     node,
+		connid:   connid,
+		callback: callback,
+	}
+	call.timer = enzo.clock.AfterFunc(remoteTimeout, func() {
+		enzo.settleRemote(id, nil, ErrTimeout)
+	})
+	enzo.pending[id] = call
+	enzo.clusterLock.Unlock()
+
+	e.Node, e.Kind, e.ID = enzo.node, EnvelopeConn, id
+	err := enzo.publish(e)
+	if err != nil {
+		enzo.settleRemote(id, nil, err)
+	}
+	return nil
+}
+
+// settleRemote calls back a remote request with its reply or an error
+func (enzo *Enzo) settleRemote(id string, reply *Envelope, err error) {
+	enzo.clusterLock.Lock()
+	call, ok := enzo.pending[id]
+	delete(enzo.pending, id)
+	enzo.clusterLock.Unlock()
+
+	if !ok {
+		return
+	}
+	call.timer.Stop()
+
+	ctx := &Context{
+		enzo: enzo,
+		conn: remoteConnection(call.connid),
+		err:  err,
+	}
+	if reply != nil {
+		ctx.payload = payload{
+			MsgType: BackMessage,
+			Key:     reply.Key,
+			Header:  reply.Header,
+			Data:    reply.Data,
+		}
+		switch reply.Error {
+		case "":
+		case ErrTimeout.Error():
+			ctx.err = ErrTimeout
+		case ErrConnectionClosed.Error():
+			ctx.err = ErrConnectionClosed
+		default:
+			ctx.err = errors.New(reply.Error)
+		}
+	}
+	call.callback(ctx)
+}
+
+// remoteConnection stands for a connection of another node in the contexts of remote replies
+func remoteConnection(connid string) *connection {
+	return &connection{
+		id:        connid,
+		writeLock: new(sync.Mutex),
+		ctx:       context.Background(),
+	}
+}
+
+// replyRemote sends the reply of a local connection to the node which emitted the request
+func (enzo *Enzo) replyRemote(e Envelope, res *Context) {
+	reply := Envelope{
+		Node:   enzo.node,
+		Kind:   EnvelopeReply,
+		Target: e.Node,
+		ID:     e.ID,
+		Key:    e.Key,
+		Header: res.GetHeader(),
+		Data:   res.GetData(),
+	}
+	if res.IsError() {
+		reply.Error = res.Error().Error()
+	}
+	enzo.publish(reply)
+}
+
+// announce tells the other nodes a connection of this node was opened or closed
+func (enzo *Enzo) announce(kind, connid string) {
+	if enzo.getBroker() == nil {
+		return
+	}
+	enzo.publish(Envelope{Node: enzo.node, Kind: kind, Target: connid})
+}
+
+// startHeartbeat runs the heartbeat of the broker, it must be called with the lock held
+func (enzo *Enzo) startHeartbeat() {
+	if enzo.broker == nil || enzo.heartbeatStop != nil {
+		return
+	}
+	enzo.heartbeatStop = make(chan struct{})
+	go enzo.heartbeat(enzo.broker, enzo.heartbeatStop)
+}
+
+// stopHeartbeat stops the heartbeat, it must be called with the lock held
+func (enzo *Enzo) stopHeartbeat() {
+	if enzo.heartbeatStop != nil {
+		close(enzo.heartbeatStop)
+		enzo.heartbeatStop = nil
+	}
+}
+
+// heartbeat publishes the connections of this node until stop is closed,
+// on Enzo.Stop or when the broker is replaced
+func (enzo *Enzo) heartbeat(b Broker, stop <-chan struct{}) {
+	for {
+		var connids []string
+		enzo.conns.Range(func(connid, _ interface{}) bool {
+			connids = append(connids, connid.(string))
+			return true
+		})
+
+		b.Publish(Envelope{Node: enzo.node, Kind: EnvelopeHeartbeat, Data: []byte(strings.Join(connids, "\n"))})
+
+		enzo.expireNodes()
+
+		tick, timer := after(enzo.clock, heartbeatInterval)
+		select {
+		case <-tick:
+		case <-stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// route handles the envelopes of the request routing, it returns false for the others
+func (enzo *Enzo) route(e Envelope) bool {
+	switch e.Kind {
+	case EnvelopeReply:
+		if e.Target == enzo.node {
+			enzo.settleRemote(e.ID, &e, nil)
+		}
+	case EnvelopeOwn:
+		enzo.clusterLock.Lock()
+		enzo.owners[e.Target] = e.Node
+		enzo.nodes[e.Node] = enzo.clock.Now()
+		enzo.clusterLock.Unlock()
+	case EnvelopeDisown:
+		enzo.clusterLock.Lock()
+		if enzo.owners[e.Target] == e.Node {
+			delete(enzo.owners, e.Target)
+		}
+		enzo.clusterLock.Unlock()
+	case EnvelopeHeartbeat:
+		enzo.clusterLock.Lock()
+		enzo.nodes[e.Node] = enzo.clock.Now()
+		for connid, node := range enzo.owners {
+			if node == e.Node {
+				delete(enzo.owners, connid)
+			}
+		}
+		if len(e.Data) > 0 {
+			for _, connid := range strings.Split(string(e.Data), "\n") {
+				enzo.owners[connid] = e.Node
+			}
+		}
+		enzo.clusterLock.Unlock()
+	default:
+		return false
+	}
+	return true
+}
+
+// expireNodes forgets the nodes without heartbeat and fails the requests waiting for them
+func (enzo *Enzo) expireNodes() {
+	enzo.clusterLock.Lock()
+	var failed []string
+	for node, seen := range enzo.nodes {
+		if enzo.clock.Now().Sub(seen) < nodeTimeout {
+			continue
+		}
+		delete(enzo.nodes, node)
+		for connid, owner := range enzo.owners {
+			if owner == node {
+				delete(enzo.owners, connid)
+			}
+		}
+		for id, call := range enzo.pending {
+			if call.node == node {
+				failed = append(failed, id)
+			}
+		}
+	}
+	enzo.clusterLock.Unlock()
+
+	for _, id := range failed {
+		enzo.settleRemote(id, nil, ErrNodeGone)
+	}
+}
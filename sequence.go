@@ -0,0 +1,102 @@
+package enzogo
+
+import (
+	"encoding/binary"
+)
+
+const (
+	// query parameter carrying the sequence of the last frame received by a resuming client
+	resumeSeqParam = "enzo_seq"
+
+	// bytes of the sent frames kept for the replay on resume, see Enzo.EnableResume
+	maxSentBytes = 1 << 20
+)
+
+// stamp sets the next outgoing sequence on a copy of the frame and, when the connection
+// is resumable, keeps it for the replay. It must be called with the write lock held.
+func (c *connection) stamp(frame []byte) []byte {
+	c.outSeq++
+
+	f := make([]byte, len(frame))
+	copy(f, frame)
+	binary.LittleEndian.PutUint64(f[16:24], c.outSeq)
+
+	if c.grace <= 0 {
+		return f
+	}
+
+	c.sent = append(c.sent, f)
+	c.sentBytes += len(f)
+	for c.sentBytes > maxSentBytes {
+		c.sentBytes -= len(c.sent[0])
+		c.sent[0] = nil
+		c.sent = c.sent[1:]
+	}
+
+	return f
+}
+
+// replayable reports whether every frame sent since the socket was lost can be replayed,
+// it must be called with the write lock held.
+func (c *connection) replayable() bool {
+	if len(c.sent) == 0 {
+		return c.outSeq == c.detachedSeq
+	}
+	return binary.LittleEndian.Uint64(c.sent[0][16:24]) <= c.detachedSeq+1
+}
+
+// replay writes the sent frames following lastSeq in the protocol of the new socket,
+// it must be called with the write lock held.
+func (c *connection) replay(lastSeq uint64, from, to features) {
+	for _, f := range c.sent {
+		if binary.LittleEndian.Uint64(f[16:24]) > lastSeq {
+			c.writeFrame(c.convert(f, from, to))
+		}
+	}
+}
+
+// convert re-encodes a frame of the server for another protocol, a frame gets a sequence when
+// the new protocol has one. It must be called with the write lock held.
+func (c *connection) convert(frame []byte, from, to features) []byte {
+	if from == to || frame[0] == PingMessage || frame[0] == PongMessage {
+		return frame
+	}
+
+	p, err := decodeFrame(frame, from, 0)
+	if err != nil {
+		return frame
+	}
+	frame = encodePayload(p, to)
+	if to.seq {
+		frame = c.stamp(frame)
+	}
+	return frame
+}
+
+// checkSeq follows the sequence of the frames received from the client, in the read order.
+// It reports a gap to Enzo.SequenceGap and returns false for a frame already received.
+func (enzo *Enzo) checkSeq(c *connection, f features, body []byte) bool {
+	if !f.seq || len(body) < 24 || body[0] == PingMessage || body[0] == PongMessage {
+		return true
+	}
+
+	seq := binary.LittleEndian.Uint64(body[16:24])
+
+	// the first frame, or a client which started over
+	if c.inSeq == 0 || seq == 1 {
+		c.inSeq = seq
+		return true
+	}
+
+	// already received, e.g. replayed by a resumed client
+	if seq <= c.inSeq {
+		return false
+	}
+
+	if expected := c.inSeq + 1; seq != expected && enzo.SequenceGap != nil {
+		enzo.SequenceGap(connContext(enzo, c), expected, seq)
+	}
+
+	c.inSeq = seq
+	return true
+}
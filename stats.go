@@ -0,0 +1,110 @@
+package enzogo
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// ConnectionInfo is a snapshot of a live connection of this node
+type ConnectionInfo struct {
+	Connid      string    `json:"connid"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	UserAgent   string    `json:"userAgent"`
+	Protocol    string    `json:"protocol"`
+	Transport   string    `json:"transport"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	// waiting for the client to resume
+	Detached bool `json:"detached"`
+
+	// frames and their bytes read from and written to the socket
+	BytesIn     uint64 `json:"bytesIn"`
+	BytesOut    uint64 `json:"bytesOut"`
+	MessagesIn  uint64 `json:"messagesIn"`
+	MessagesOut uint64 `json:"messagesOut"`
+
+	Rooms []string `json:"rooms"`
+
+	// what the plugins implementing Inspector tell about the connection, by plugin name
+	Plugins map[string]interface{} `json:"plugins,omitempty"`
+}
+
+// Inspector is implemented by the plugins adding to the ConnectionInfo of a connection
+type Inspector interface {
+	Inspect(connid string) interface{}
+}
+
+// Connections returns the live connections of this node, sorted by connection time
+func (enzo *Enzo) Connections() []ConnectionInfo {
+	list := []ConnectionInfo{}
+	enzo.conns.Range(func(_, c interface{}) bool {
+		list = append(list, enzo.inspect(c.(*connection)))
+		return true
+	})
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ConnectedAt.Before(list[j].ConnectedAt)
+	})
+	return list
+}
+
+// Connection returns a live connection of this node by its id
+func (enzo *Enzo) Connection(connid string) (ConnectionInfo, bool) {
+	c, ok := enzo.conns.Load(connid)
+	if !ok {
+		return ConnectionInfo{}, false
+	}
+	return enzo.inspect(c.(*connection)), true
+}
+
+// Kick closes a connection of this node, see Context.Close
+func (enzo *Enzo) Kick(connid string, code int, text string) error {
+	c, ok := enzo.conns.Load(connid)
+	if !ok {
+		return ErrConnNotFound
+	}
+	return enzo.kick(c.(*connection), code, text)
+}
+
+func (enzo *Enzo) inspect(c *connection) ConnectionInfo {
+	info := ConnectionInfo{
+		Connid:      c.id,
+		ConnectedAt: c.connectedAt,
+		BytesIn:     atomic.LoadUint64(&c.bytesIn),
+		BytesOut:    atomic.LoadUint64(&c.bytesOut),
+		MessagesIn:  atomic.LoadUint64(&c.messagesIn),
+		MessagesOut: atomic.LoadUint64(&c.messagesOut),
+	}
+
+	if c.req != nil {
+		info.UserAgent = c.req.UserAgent()
+	}
+
+	c.writeLock.Lock()
+	if addr := c.transport.RemoteAddr(); addr != nil {
+		info.RemoteAddr = addr.String()
+	}
+	info.Protocol = c.protocol
+	info.Transport = transportName(c.transport)
+	info.Detached = c.detached
+	c.writeLock.Unlock()
+
+	enzo.roomsLock.Lock()
+	info.Rooms = make([]string, 0, len(c.rooms))
+	for room := range c.rooms {
+		info.Rooms = append(info.Rooms, room)
+	}
+	enzo.roomsLock.Unlock()
+	sort.Strings(info.Rooms)
+
+	for _, p := range enzo.installed() {
+		if i, ok := p.(Inspector); ok {
+			if info.Plugins == nil {
+				info.Plugins = map[string]interface{}{}
+			}
+			info.Plugins[p.Name()] = i.Inspect(c.id)
+		}
+	}
+
+	return info
+}
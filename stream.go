@@ -0,0 +1,73 @@
+package enzogo
+
+import (
+	"errors"
+	"sync"
+)
+
+// status of a StreamMessage, the first byte of its data
+const (
+	streamData  byte = 0x00
+	streamEnd   byte = 0x01
+	streamError byte = 0x02
+)
+
+var ErrStreamClosed = errors.New("stream closed")
+
+// Stream sends multiple partial replies to one message, see Context.Stream
+type Stream struct {
+	ctx    *Context
+	lock   sync.Mutex
+	closed bool
+}
+
+var _ interface {
+	Write([]byte) (int, error)
+	Close() error
+} = (*Stream)(nil)
+
+// Stream replies to the message with a sequence of partial replies instead of a single Write.
+// The default reply is cancelled, the stream must be ended with Close or CloseWithError.
+func (ctx *Context) Stream() *Stream {
+	ctx.markReplied()
+
+	return &Stream{ctx: ctx}
+}
+
+// Write sends p as one partial reply
+func (s *Stream) Write(p []byte) (int, error) {
+	if err := s.send(streamData, p, false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close sends the end marker of the stream
+func (s *Stream) Close() error {
+	return s.send(streamEnd, nil, true)
+}
+
+// CloseWithError ends the stream with an error, which is raised by the client iterator
+func (s *Stream) CloseWithError(err error) error {
+	return s.send(streamError, []byte(err.Error()), true)
+}
+
+func (s *Stream) send(status byte, data []byte, closing bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return ErrStreamClosed
+	}
+	if closing {
+		s.closed = true
+		defer s.ctx.conn.release(s.ctx.payload.MsgID)
+	}
+
+	var err error
+	ctx := s.ctx
+	ctx.write(StreamMessage, false, ctx.payload.MsgID, ctx.payload.Key, append([]byte{status}, data...), func(ictx *Context) {
+		err = ictx.Error()
+	})
+	return err
+}
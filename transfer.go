@@ -0,0 +1,378 @@
+package enzogo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// size of the data carried by one ChunkMessage
+	chunkSize = 64 << 10
+
+	// an unfinished transfer is dropped after this long without a chunk,
+	// until then the sender can resume it, even from a resumed socket
+	transferTimeout = time.Minute
+
+	// incoming transfers in flight on one connection
+	maxTransfers = 4
+
+	chunkOK    byte = 0x01
+	chunkError byte = 0x02
+)
+
+// incoming transfer, keyed by its message id
+type transfer struct {
+	lock     sync.Mutex
+	key      string
+	total    int64
+	received int64
+	data     []byte
+	timer    Timer
+}
+
+// SetMaxTransferSize sets the largest payload accepted by a chunked transfer
+func (enzo *Enzo) SetMaxTransferSize(size int64) {
+	enzo.lock.Lock()
+	defer enzo.lock.Unlock()
+
+	enzo.maxTransferSize = size
+}
+
+func (enzo *Enzo) transferLimit() int64 {
+	enzo.lock.Lock()
+	defer enzo.lock.Unlock()
+
+	return enzo.maxTransferSize
+}
+
+// Transferred returns the progress of the chunked transfer that triggered the "transfer" event
+func (ctx *Context) Transferred() (received, total int64) {
+	return ctx.transferred, ctx.transferSize
+}
+
+// Transfer sends a large payload to the client as a sequence of chunk frames.
+// Every chunk is acknowledged before the next one is sent, progress is called after each
+// acknowledgement, and the callback receives the client reply just like LongtimeEmit:
+// it fails with ErrConnectionClosed when the connection is gone before the reply.
+func (ctx *Context) Transfer(key string, r io.ReaderAt, size int64, progress func(sent, total int64), cb ...Handle) error {
+	if ctx.transport == nil {
+		return ErrConnectionClosed
+	}
+	if size < 0 || size > ctx.enzo.transferLimit() {
+		return errors.New("transfer too large")
+	}
+
+	var callback Handle
+
+	if cb == nil {
+		callback = func(ctx *Context) {}
+	} else {
+		callback = cb[0]
+	}
+
+	if progress == nil {
+		progress = func(sent, total int64) {}
+	}
+
+	go ctx.transfer(makeMsgId(), key, r, size, progress, callback)
+
+	return nil
+}
+
+func (ctx *Context) transfer(msgid []byte, key string, r io.ReaderAt, size int64, progress func(sent, total int64), callback Handle) {
+	acks := make(chan *Context, 1)
+	failed := make(chan error, 1)
+
+	ackEvent := chunkAckEvent(msgid)
+	ackHandler := ctx.enzo.emitter.On(ackEvent, func(ack *Context) {
+		select {
+		case acks <- ack:
+		default:
+		}
+	})
+	defer ctx.enzo.emitter.RemoveListener(ackEvent, ackHandler)
+
+	// the reply of the client, after the last chunk
+	settle := ctx.waitBack(msgid, true, callback)
+
+	fail := func(err error) {
+		settle(ctx.errorContext(err))
+	}
+
+	buf := make([]byte, chunkSize)
+	var offset int64
+
+	for {
+		n := int64(len(buf))
+		if size-offset < n {
+			n = size - offset
+		}
+		read, err := r.ReadAt(buf[:n], offset)
+		if err != nil && !(err == io.EOF && int64(read) == n) {
+			fail(err)
+			return
+		}
+
+		ctx.write(ChunkMessage, true, msgid, key, encodeChunk(size, offset, buf[:n]), func(ictx *Context) {
+			select {
+			case failed <- ictx.Error():
+			default:
+			}
+		})
+
+		timeout, timer := after(ctx.enzo.clock, 6*time.Second)
+		select {
+		case ack := <-acks:
+			timer.Stop()
+			status, received, msg := decodeChunkAck(ack.GetData())
+			if status != chunkOK {
+				fail(errors.New(msg))
+				return
+			}
+
+			// the client tells where to continue, so a resumed transfer may rewind
+			offset = received
+			progress(offset, size)
+
+			if offset >= size {
+				return
+			}
+		case err := <-failed:
+			timer.Stop()
+			fail(err)
+			return
+		case <-timeout:
+			fail(ErrTimeout)
+			return
+		}
+	}
+}
+
+// receiveChunk appends a chunk to its transfer and dispatches the whole payload once complete
+func (enzo *Enzo) receiveChunk(c *connection, p payload) {
+	ctx := connContext(enzo, c)
+	ack := func(status byte, received int64, msg string) {
+		ctx.write(ChunkAckMessage, true, p.MsgID, p.Key, encodeChunkAck(status, received, msg), func(ctx *Context) {})
+	}
+
+	total, offset, chunk, err := decodeChunk(p.Data)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if total > enzo.transferLimit() {
+		ack(chunkError, 0, "transfer too large")
+		return
+	}
+
+	id := bytes2BHex(p.MsgID)
+	if total, ok := c.completedTransfer(id); ok {
+		// the ack of the final chunk was lost
+		ack(chunkOK, total, "")
+		return
+	}
+	t, ok := c.openTransfer(id, p.Key, total)
+	if !ok {
+		ack(chunkError, 0, "too many transfers")
+		return
+	}
+
+	t.lock.Lock()
+
+	if t.total != total || t.key != p.Key {
+		t.lock.Unlock()
+		ack(chunkError, t.received, "mismatched transfer")
+		return
+	}
+
+	// a chunk already received, or a gap: tell the sender where to continue
+	if offset != t.received {
+		t.lock.Unlock()
+		ack(chunkOK, t.received, "")
+		return
+	}
+
+	// grown with the chunks, the total announced by the sender is not trusted
+	t.data = append(t.data, chunk...)
+	t.received += int64(len(chunk))
+
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.timer = enzo.clock.AfterFunc(transferTimeout, func() {
+		c.closeTransfer(id, t)
+	})
+
+	received := t.received
+	done := received >= t.total
+	if done {
+		t.timer.Stop()
+		c.completeTransfer(id, t)
+		enzo.clock.AfterFunc(transferTimeout, func() {
+			c.forgetCompleted(id)
+		})
+	}
+
+	t.lock.Unlock()
+
+	ct, _ := c.current()
+	enzo.emitter.Emit("transfer", &Context{
+		enzo:      enzo,
+		conn:      c,
+		transport: ct,
+		Conn:      socketOf(ct),
+		payload: payload{
+			MsgType:  ChunkMessage,
+			MsgID:    p.MsgID,
+			Longtime: true,
+			Key:      p.Key,
+			Header:   p.Header,
+		},
+		transferred:  received,
+		transferSize: total,
+	})
+
+	ack(chunkOK, received, "")
+
+	if done {
+		enzo.dispatch(newContext(enzo, c, payload{
+			MsgType:  PostMessage,
+			MsgID:    p.MsgID,
+			Longtime: true,
+			Key:      p.Key,
+			Header:   p.Header,
+			Data:     t.data,
+		}))
+	}
+}
+
+// openTransfer returns the incoming transfer of the message id,
+// a new one unless maxTransfers are already in flight
+func (c *connection) openTransfer(id, key string, total int64) (*transfer, bool) {
+	c.transfersLock.Lock()
+	defer c.transfersLock.Unlock()
+
+	if t, ok := c.transfers[id]; ok {
+		return t, true
+	}
+	if len(c.transfers) >= maxTransfers {
+		return nil, false
+	}
+
+	if c.transfers == nil {
+		c.transfers = map[string]*transfer{}
+	}
+	t := &transfer{key: key, total: total}
+	c.transfers[id] = t
+	return t, true
+}
+
+func (c *connection) closeTransfer(id string, t *transfer) {
+	c.transfersLock.Lock()
+	defer c.transfersLock.Unlock()
+
+	if c.transfers[id] == t {
+		delete(c.transfers, id)
+	}
+}
+
+// completeTransfer moves a finished transfer to the completed ones
+func (c *connection) completeTransfer(id string, t *transfer) {
+	c.transfersLock.Lock()
+	defer c.transfersLock.Unlock()
+
+	if c.transfers[id] == t {
+		delete(c.transfers, id)
+	}
+	if c.completed == nil {
+		c.completed = map[string]int64{}
+	}
+	c.completed[id] = t.total
+}
+
+// completedTransfer returns the total of a completed transfer
+func (c *connection) completedTransfer(id string) (int64, bool) {
+	c.transfersLock.Lock()
+	defer c.transfersLock.Unlock()
+
+	total, ok := c.completed[id]
+	return total, ok
+}
+
+func (c *connection) forgetCompleted(id string) {
+	c.transfersLock.Lock()
+	defer c.transfersLock.Unlock()
+
+	delete(c.completed, id)
+}
+
+// dropTransfers forgets the transfers of a connection which is gone
+func (c *connection) dropTransfers() {
+	c.transfersLock.Lock()
+	transfers := c.transfers
+	c.transfers = nil
+	c.completed = nil
+	c.transfersLock.Unlock()
+
+	for _, t := range transfers {
+		t.lock.Lock()
+		if t.timer != nil {
+			t.timer.Stop()
+		}
+		t.data = nil
+		t.lock.Unlock()
+	}
+}
+
+func chunkAckEvent(msgid []byte) string {
+	return "ack:" + bytes2BHex(msgid)
+}
+
+// chunk data: | total(8) | offset(8) | chunk(x) |
+func encodeChunk(total, offset int64, chunk []byte) []byte {
+	b := make([]byte, 16+len(chunk))
+	binary.LittleEndian.PutUint64(b[0:8], uint64(total))
+	binary.LittleEndian.PutUint64(b[8:16], uint64(offset))
+	copy(b[16:], chunk)
+	return b
+}
+
+func decodeChunk(b []byte) (total, offset int64, chunk []byte, err error) {
+	if len(b) < 16 {
+		return 0, 0, nil, errMismatchedLength
+	}
+	total = int64(binary.LittleEndian.Uint64(b[0:8]))
+	offset = int64(binary.LittleEndian.Uint64(b[8:16]))
+	chunk = b[16:]
+	if total < 0 || offset < 0 || offset+int64(len(chunk)) > total {
+		return 0, 0, nil, errMismatchedLength
+	}
+	return total, offset, chunk, nil
+}
+
+// chunk ack data: | status(1) | received(8) | message(x) |
+func encodeChunkAck(status byte, received int64, msg string) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteByte(status)
+
+	r := make([]byte, 8)
+	binary.LittleEndian.PutUint64(r, uint64(received))
+	buf.Write(r)
+
+	buf.WriteString(msg)
+
+	return buf.Bytes()
+}
+
+func decodeChunkAck(b []byte) (status byte, received int64, msg string) {
+	if len(b) < 9 {
+		return chunkError, 0, errMismatchedLength.Error()
+	}
+	return b[0], int64(binary.LittleEndian.Uint64(b[1:9])), string(b[9:])
+}
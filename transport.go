@@ -0,0 +1,119 @@
+package enzogo
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Transport carries the frames of a connection: the websocket of ServeHTTP, the long-polling
+// fallback, a pipe or a stream connection, or one of your own served with ServeTransport.
+// ReadFrame is only called by the read loop and WriteFrame is never called concurrently,
+// Close may be called at any time.
+type Transport interface {
+	// ReadFrame blocks until the next frame of the client,
+	// it returns a *CloseError once the client closed the transport.
+	ReadFrame() ([]byte, error)
+	WriteFrame(frame []byte) error
+	// SetReadDeadline fails the pending and future reads after t, the zero t disables it
+	SetReadDeadline(t time.Time) error
+	// Close ends the transport, the client is told the close code and text when possible
+	Close(code int, text string) error
+	RemoteAddr() net.Addr
+}
+
+// ServeTransport runs a connection over the transport until it is closed. r is the request
+// which opened the transport, nil when there is none: then GetHttpRequest returns nil,
+// GenerateConnid is called with nil and the connection can not be resumed.
+func (enzo *Enzo) ServeTransport(t Transport, protocol string, r *http.Request) error {
+	if !enzo.supportsProtocol(protocol) {
+		t.Close(CloseProtocolError, "unsupported protocol")
+		return errors.New("unsupported protocol \"" + protocol + "\"")
+	}
+
+	enzo.serve(t, r, protocol)
+	return nil
+}
+
+// wsTransport is the websocket transport of gorilla/websocket
+type wsTransport struct {
+	conn *websocket.Conn
+	// messages of at least this size are compressed, -1 disables compression
+	compressionThreshold int
+}
+
+func (t *wsTransport) ReadFrame() ([]byte, error) {
+	_, p, err := t.conn.ReadMessage()
+
+	var ce *websocket.CloseError
+	if errors.As(err, &ce) {
+		return nil, &CloseError{Code: ce.Code, Text: ce.Text}
+	}
+	return p, err
+}
+
+func (t *wsTransport) WriteFrame(frame []byte) error {
+	if t.compressionThreshold >= 0 {
+		t.conn.EnableWriteCompression(len(frame) >= t.compressionThreshold)
+	}
+
+	return t.conn.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+func (t *wsTransport) SetReadDeadline(d time.Time) error {
+	return t.conn.SetReadDeadline(d)
+}
+
+func (t *wsTransport) RemoteAddr() net.Addr {
+	return t.conn.RemoteAddr()
+}
+
+// Close sends a close frame, the read loop ends once the client echoes it or after a second
+func (t *wsTransport) Close(code int, text string) error {
+	err := t.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+
+	conn := t.conn
+	time.AfterFunc(time.Second, func() {
+		conn.Close()
+	})
+
+	return err
+}
+
+// socketOf returns the websocket of a transport, nil for the other transports
+func socketOf(t Transport) *websocket.Conn {
+	if t, ok := t.(*wsTransport); ok {
+		return t.conn
+	}
+	return nil
+}
+
+// connContext returns a Context of the connection without a message
+func connContext(enzo *Enzo, c *connection) *Context {
+	t, _ := c.current()
+	return &Context{
+		enzo:      enzo,
+		conn:      c,
+		transport: t,
+		Conn:      socketOf(t),
+	}
+}
+
+// transportName names the transport in a ConnectionInfo
+func transportName(t Transport) string {
+	switch t := t.(type) {
+	case *wsTransport:
+		return "websocket"
+	case *pollTransport:
+		return "polling"
+	case *pipeEnd:
+		return "pipe"
+	case *connTransport:
+		return t.RemoteAddr().Network()
+	default:
+		return "other"
+	}
+}